@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/profitbricks/profitbricks-sdk-go"
+)
+
+//CloudAPI represents the ProfitBricks Cloud API operations used by the driver.
+type CloudAPI interface {
+	ListVolumes(dcid string) (*profitbricks.Volumes, error)
+	GetVolume(dcid string, volumeID string) (*profitbricks.Volume, error)
+	CreateVolume(dcid string, request profitbricks.Volume) (*profitbricks.Volume, error)
+	UpdateVolume(dcid string, volid string, request profitbricks.VolumeProperties) (*profitbricks.Volume, error)
+	DeleteVolume(dcid, volid string) (*http.Header, error)
+	AttachVolume(dcid string, srvid string, volid string) (*profitbricks.Volume, error)
+	DetachVolume(dcid, srvid, volid string) (*http.Header, error)
+	ListAttachedVolumes(dcid, srvid string) (*profitbricks.Volumes, error)
+	GetAttachedVolume(dcid, srvid, volid string) (*profitbricks.Volume, error)
+	ListServers(dcid string) (*profitbricks.Servers, error)
+	GetServer(dcid, srvid string) (*profitbricks.Server, error)
+	ListSnapshots() (*profitbricks.Snapshots, error)
+	GetSnapshot(snapshotID string) (*profitbricks.Snapshot, error)
+	GetRequestStatus(path string) (*profitbricks.RequestStatus, error)
+}
+
+//The SDK client is the production implementation of the CloudAPI.
+var _ CloudAPI = (*sdkCloud)(nil)
+
+//httpStatusCoder is implemented by errors carrying a Cloud API http status.
+type httpStatusCoder interface {
+	HttpStatusCode() int
+}
+
+//apiStatusCode returns the http status code of a Cloud API error.
+func apiStatusCode(err error) (int, bool) {
+	if apiError, ok := err.(httpStatusCoder); ok {
+		return apiError.HttpStatusCode(), true
+	}
+	return 0, false
+}
+
+//isNotFound reports whether a Cloud API error is a 404.
+func isNotFound(err error) bool {
+	code, ok := apiStatusCode(err)
+	return ok && code == http.StatusNotFound
+}
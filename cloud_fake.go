@@ -0,0 +1,483 @@
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/profitbricks/profitbricks-sdk-go"
+)
+
+const (
+	fakeRequestPrefix = "/requests/"
+	fakeRequestSuffix = "/status"
+)
+
+//fakeAPIError represents a Cloud API error returned by the fake backend.
+type fakeAPIError struct {
+	status  int
+	message string
+}
+
+//Error is returning a message of the error.
+func (e fakeAPIError) Error() string {
+	return fmt.Sprintf("HTTP Status: %d \nError Messages:%s\n", e.status, e.message)
+}
+
+//HttpStatusCode is returning a http status of the error.
+func (e fakeAPIError) HttpStatusCode() int {
+	return e.status
+}
+
+//fakeVolume represents a volume stored by the fake backend.
+type fakeVolume struct {
+	DatacenterID string
+	ServerID     string
+	Volume       profitbricks.Volume
+}
+
+//fakeRequest represents a long running request of the fake backend.
+type fakeRequest struct {
+	statuses []string
+	message  string
+	apply    func()
+}
+
+//fakeCloud is an in-memory implementation of the CloudAPI.
+type fakeCloud struct {
+	sync.Mutex
+	volumes   map[string]*fakeVolume
+	snapshots map[string]*profitbricks.Snapshot
+	requests  map[string]*fakeRequest
+	//servers holds the vm state of servers volumes were attached to.
+	servers map[string]string
+
+	//statuses is the sequence every new request goes through.
+	statuses []string
+	//failures are errors returned by the next call of a method.
+	failures map[string]error
+	//failedRequests are messages of the next request of a method ending as FAILED.
+	failedRequests map[string]string
+}
+
+//newFakeCloud is a constructor of the in-memory CloudAPI.
+func newFakeCloud() *fakeCloud {
+	return &fakeCloud{
+		volumes:        make(map[string]*fakeVolume),
+		snapshots:      make(map[string]*profitbricks.Snapshot),
+		requests:       make(map[string]*fakeRequest),
+		servers:        make(map[string]string),
+		statuses:       []string{"DONE"},
+		failures:       make(map[string]error),
+		failedRequests: make(map[string]string),
+	}
+}
+
+//failNext makes the next call of the method return the error.
+func (c *fakeCloud) failNext(method string, err error) {
+	c.Lock()
+	defer c.Unlock()
+	c.failures[method] = err
+}
+
+//failRequest makes the next request issued by the method end with the FAILED status.
+func (c *fakeCloud) failRequest(method string, message string) {
+	c.Lock()
+	defer c.Unlock()
+	c.failedRequests[method] = message
+}
+
+//addSnapshot registers a snapshot volumes can be created from.
+func (c *fakeCloud) addSnapshot(name string) string {
+	c.Lock()
+	defer c.Unlock()
+	id := c.newID()
+	c.snapshots[id] = &profitbricks.Snapshot{
+		ID:         id,
+		Properties: profitbricks.SnapshotProperties{Name: name},
+	}
+	return id
+}
+
+//setServerState sets the vm state of a server, e.g. SHUTOFF for stopped servers.
+func (c *fakeCloud) setServerState(srvid string, vmState string) {
+	c.Lock()
+	defer c.Unlock()
+	c.servers[srvid] = vmState
+}
+
+//newID is generating a random uuid.
+func (c *fakeCloud) newID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+//takeFailure is returning an injected error for the method.
+func (c *fakeCloud) takeFailure(method string) error {
+	err, ok := c.failures[method]
+	if ok {
+		delete(c.failures, method)
+	}
+	return err
+}
+
+//newRequest is registering a request whose changes are applied once it is DONE.
+func (c *fakeCloud) newRequest(method string, apply func()) *http.Header {
+	id := c.newID()
+	req := &fakeRequest{
+		statuses: append([]string{}, c.statuses...),
+		apply:    apply,
+	}
+	if message, ok := c.failedRequests[method]; ok {
+		delete(c.failedRequests, method)
+		req.statuses[len(req.statuses)-1] = "FAILED"
+		req.message = message
+	}
+	c.requests[id] = req
+
+	headers := http.Header{}
+	headers.Set("Location", fakeRequestPrefix+id+fakeRequestSuffix)
+	return &headers
+}
+
+//notFound is returning a 404 error for a resource.
+func notFound(resource string, id string) error {
+	return fakeAPIError{status: http.StatusNotFound, message: fmt.Sprintf("Resource %s %s does not exist", resource, id)}
+}
+
+//getVolume is returning a volume located in the datacenter.
+func (c *fakeCloud) getVolume(dcid string, volumeID string) (*fakeVolume, error) {
+	vol, ok := c.volumes[volumeID]
+	if !ok || vol.DatacenterID != dcid {
+		return nil, notFound("volume", volumeID)
+	}
+	return vol, nil
+}
+
+//ListVolumes is listing volumes of the datacenter.
+func (c *fakeCloud) ListVolumes(dcid string) (*profitbricks.Volumes, error) {
+	c.Lock()
+	defer c.Unlock()
+	ret := &profitbricks.Volumes{}
+	if err := c.takeFailure("ListVolumes"); err != nil {
+		return ret, err
+	}
+
+	for _, vol := range c.volumes {
+		if vol.DatacenterID == dcid {
+			ret.Items = append(ret.Items, vol.Volume)
+		}
+	}
+	return ret, nil
+}
+
+//GetVolume is getting a volume.
+func (c *fakeCloud) GetVolume(dcid string, volumeID string) (*profitbricks.Volume, error) {
+	c.Lock()
+	defer c.Unlock()
+	if err := c.takeFailure("GetVolume"); err != nil {
+		return &profitbricks.Volume{}, err
+	}
+
+	vol, err := c.getVolume(dcid, volumeID)
+	if err != nil {
+		return &profitbricks.Volume{}, err
+	}
+	ret := vol.Volume
+	return &ret, nil
+}
+
+//CreateVolume is creating a volume.
+func (c *fakeCloud) CreateVolume(dcid string, request profitbricks.Volume) (*profitbricks.Volume, error) {
+	c.Lock()
+	defer c.Unlock()
+	if err := c.takeFailure("CreateVolume"); err != nil {
+		return &profitbricks.Volume{}, err
+	}
+
+	if request.Properties.Image != "" {
+		if _, ok := c.snapshots[request.Properties.Image]; !ok {
+			return &profitbricks.Volume{}, notFound("snapshot", request.Properties.Image)
+		}
+	}
+
+	ret := request
+	ret.ID = c.newID()
+	ret.Headers = c.newRequest("CreateVolume", func() {
+		c.volumes[ret.ID] = &fakeVolume{
+			DatacenterID: dcid,
+			Volume: profitbricks.Volume{
+				ID:         ret.ID,
+				Metadata:   &profitbricks.Metadata{CreatedDate: time.Now().UTC(), State: "AVAILABLE"},
+				Properties: request.Properties,
+			},
+		}
+	})
+	return &ret, nil
+}
+
+//UpdateVolume is updating volume properties.
+func (c *fakeCloud) UpdateVolume(dcid string, volid string, request profitbricks.VolumeProperties) (*profitbricks.Volume, error) {
+	c.Lock()
+	defer c.Unlock()
+	if err := c.takeFailure("UpdateVolume"); err != nil {
+		return &profitbricks.Volume{}, err
+	}
+
+	vol, err := c.getVolume(dcid, volid)
+	if err != nil {
+		return &profitbricks.Volume{}, err
+	}
+
+	ret := vol.Volume
+	if request.Name != "" {
+		ret.Properties.Name = request.Name
+	}
+	if request.Size != 0 {
+		ret.Properties.Size = request.Size
+	}
+	properties := ret.Properties
+	ret.Headers = c.newRequest("UpdateVolume", func() {
+		vol.Volume.Properties = properties
+	})
+	return &ret, nil
+}
+
+//DeleteVolume is deleting a volume.
+func (c *fakeCloud) DeleteVolume(dcid, volid string) (*http.Header, error) {
+	c.Lock()
+	defer c.Unlock()
+	if err := c.takeFailure("DeleteVolume"); err != nil {
+		return &http.Header{}, err
+	}
+
+	if _, err := c.getVolume(dcid, volid); err != nil {
+		return &http.Header{}, err
+	}
+
+	return c.newRequest("DeleteVolume", func() {
+		delete(c.volumes, volid)
+	}), nil
+}
+
+//AttachVolume is attaching a volume to a server.
+func (c *fakeCloud) AttachVolume(dcid string, srvid string, volid string) (*profitbricks.Volume, error) {
+	c.Lock()
+	defer c.Unlock()
+	if err := c.takeFailure("AttachVolume"); err != nil {
+		return &profitbricks.Volume{}, err
+	}
+
+	vol, err := c.getVolume(dcid, volid)
+	if err != nil {
+		return &profitbricks.Volume{}, err
+	}
+	if vol.ServerID != "" && vol.ServerID != srvid {
+		return &profitbricks.Volume{}, fakeAPIError{
+			status:  http.StatusUnprocessableEntity,
+			message: fmt.Sprintf("Volume %s is already attached to server %s", volid, vol.ServerID),
+		}
+	}
+
+	if _, ok := c.servers[srvid]; !ok {
+		c.servers[srvid] = "RUNNING"
+	}
+	ret := vol.Volume
+	ret.Headers = c.newRequest("AttachVolume", func() {
+		//Providers with their own devices set the bus before the attachment is done
+		if vol.ServerID != srvid && vol.Volume.Properties.Bus == "" {
+			vol.Volume.Properties.Bus = busVirtio
+			vol.Volume.Properties.DeviceNumber = c.nextDeviceNumber(dcid, srvid)
+		}
+		vol.ServerID = srvid
+	})
+	return &ret, nil
+}
+
+//nextDeviceNumber is returning the lowest free device number of a server.
+//Device number 1 is reserved for the boot volume. The caller has to hold the lock.
+func (c *fakeCloud) nextDeviceNumber(dcid, srvid string) int64 {
+	used := make(map[int64]bool)
+	for _, vol := range c.volumes {
+		if vol.DatacenterID == dcid && vol.ServerID == srvid {
+			used[vol.Volume.Properties.DeviceNumber] = true
+		}
+	}
+	number := int64(2)
+	for used[number] {
+		number++
+	}
+	return number
+}
+
+//DetachVolume is detaching a volume from a server.
+func (c *fakeCloud) DetachVolume(dcid, srvid, volid string) (*http.Header, error) {
+	c.Lock()
+	defer c.Unlock()
+	if err := c.takeFailure("DetachVolume"); err != nil {
+		return &http.Header{}, err
+	}
+
+	vol, err := c.getVolume(dcid, volid)
+	if err != nil {
+		return &http.Header{}, err
+	}
+	if vol.ServerID != srvid {
+		return &http.Header{}, notFound("attached volume", volid)
+	}
+
+	return c.newRequest("DetachVolume", func() {
+		vol.ServerID = ""
+		vol.Volume.Properties.Bus = ""
+		vol.Volume.Properties.DeviceNumber = 0
+	}), nil
+}
+
+//ListAttachedVolumes is listing volumes attached to a server.
+func (c *fakeCloud) ListAttachedVolumes(dcid, srvid string) (*profitbricks.Volumes, error) {
+	c.Lock()
+	defer c.Unlock()
+	ret := &profitbricks.Volumes{}
+	if err := c.takeFailure("ListAttachedVolumes"); err != nil {
+		return ret, err
+	}
+
+	for _, vol := range c.volumes {
+		if vol.DatacenterID == dcid && vol.ServerID == srvid {
+			ret.Items = append(ret.Items, vol.Volume)
+		}
+	}
+	return ret, nil
+}
+
+//GetAttachedVolume is getting a volume attached to a server, including its bus and device number.
+func (c *fakeCloud) GetAttachedVolume(dcid, srvid, volid string) (*profitbricks.Volume, error) {
+	c.Lock()
+	defer c.Unlock()
+	if err := c.takeFailure("GetAttachedVolume"); err != nil {
+		return &profitbricks.Volume{}, err
+	}
+
+	vol, err := c.getVolume(dcid, volid)
+	if err != nil {
+		return &profitbricks.Volume{}, err
+	}
+	if vol.ServerID != srvid {
+		return &profitbricks.Volume{}, notFound("attached volume", volid)
+	}
+	ret := vol.Volume
+	return &ret, nil
+}
+
+//server is returning a server including its attached volumes.
+func (c *fakeCloud) server(dcid, srvid string) profitbricks.Server {
+	volumes := &profitbricks.Volumes{}
+	for _, vol := range c.volumes {
+		if vol.DatacenterID == dcid && vol.ServerID == srvid {
+			volumes.Items = append(volumes.Items, vol.Volume)
+		}
+	}
+	return profitbricks.Server{
+		ID:         srvid,
+		Properties: profitbricks.ServerProperties{Name: srvid, VMState: c.servers[srvid]},
+		Entities:   &profitbricks.ServerEntities{Volumes: volumes},
+	}
+}
+
+//ListServers is listing servers volumes were attached to.
+func (c *fakeCloud) ListServers(dcid string) (*profitbricks.Servers, error) {
+	c.Lock()
+	defer c.Unlock()
+	ret := &profitbricks.Servers{}
+	if err := c.takeFailure("ListServers"); err != nil {
+		return ret, err
+	}
+
+	for srvid := range c.servers {
+		ret.Items = append(ret.Items, c.server(dcid, srvid))
+	}
+	return ret, nil
+}
+
+//GetServer is getting a server.
+func (c *fakeCloud) GetServer(dcid, srvid string) (*profitbricks.Server, error) {
+	c.Lock()
+	defer c.Unlock()
+	if err := c.takeFailure("GetServer"); err != nil {
+		return &profitbricks.Server{}, err
+	}
+
+	if _, ok := c.servers[srvid]; !ok {
+		return &profitbricks.Server{}, notFound("server", srvid)
+	}
+	ret := c.server(dcid, srvid)
+	return &ret, nil
+}
+
+//ListSnapshots is listing snapshots.
+func (c *fakeCloud) ListSnapshots() (*profitbricks.Snapshots, error) {
+	c.Lock()
+	defer c.Unlock()
+	ret := &profitbricks.Snapshots{}
+	if err := c.takeFailure("ListSnapshots"); err != nil {
+		return ret, err
+	}
+
+	for _, snapshot := range c.snapshots {
+		ret.Items = append(ret.Items, *snapshot)
+	}
+	return ret, nil
+}
+
+//GetSnapshot is getting a snapshot.
+func (c *fakeCloud) GetSnapshot(snapshotID string) (*profitbricks.Snapshot, error) {
+	c.Lock()
+	defer c.Unlock()
+	if err := c.takeFailure("GetSnapshot"); err != nil {
+		return &profitbricks.Snapshot{}, err
+	}
+
+	snapshot, ok := c.snapshots[snapshotID]
+	if !ok {
+		return &profitbricks.Snapshot{}, notFound("snapshot", snapshotID)
+	}
+	ret := *snapshot
+	return &ret, nil
+}
+
+//GetRequestStatus is returning the current status of a request and moves it to the next one.
+func (c *fakeCloud) GetRequestStatus(path string) (*profitbricks.RequestStatus, error) {
+	c.Lock()
+	defer c.Unlock()
+	ret := &profitbricks.RequestStatus{}
+	if err := c.takeFailure("GetRequestStatus"); err != nil {
+		return ret, err
+	}
+
+	id := strings.TrimSuffix(strings.TrimPrefix(path, fakeRequestPrefix), fakeRequestSuffix)
+	req, ok := c.requests[id]
+	if !ok {
+		return ret, notFound("request", id)
+	}
+
+	ret.ID = id
+	ret.Metadata.Status = req.statuses[0]
+	if len(req.statuses) > 1 {
+		req.statuses = req.statuses[1:]
+		return ret, nil
+	}
+
+	if ret.Metadata.Status == "FAILED" {
+		ret.Metadata.Message = req.message
+	} else if req.apply != nil {
+		req.apply()
+		req.apply = nil
+	}
+	return ret, nil
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/profitbricks/profitbricks-sdk-go"
+)
+
+//Constances used by the loop provider.
+const (
+	loopStateFile  = "volumes.json"
+	loopImageExt   = ".img"
+	loopBus        = "LOOP"
+	loopDevicePath = "/dev/loop"
+	gigabyte       = 1 << 30
+)
+
+//loopCloud is a CloudAPI keeping volumes as sparse files which are bound to
+//loop devices when attached, so they appear as new disks on the host.
+type loopCloud struct {
+	*fakeCloud
+	path string
+	host Host
+}
+
+//newLoopCloud is a constructor of the loop provider storing images under path.
+func newLoopCloud(path string, host Host) (*loopCloud, error) {
+	err := os.MkdirAll(path, metadataDirMode)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &loopCloud{
+		fakeCloud: newFakeCloud(),
+		path:      path,
+		host:      host,
+	}
+	err = c.load()
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+//LoopDriver is a constructor of the driver using loop devices instead of the Cloud API.
+func LoopDriver(utilities *Utilities, args CommandLineArgs) (*Driver, error) {
+	client, err := newLoopCloud(*args.loopPath, utilities.host)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewDriver(client, utilities, args)
+}
+
+//imagePath is returning the path of the sparse file of a volume.
+func (c *loopCloud) imagePath(volumeID string) string {
+	return filepath.Join(c.path, volumeID+loopImageExt)
+}
+
+//load is loading volumes and dropping attachments of images no longer bound to a loop device.
+func (c *loopCloud) load() error {
+	c.Lock()
+	defer c.Unlock()
+
+	data, err := ioutil.ReadFile(filepath.Join(c.path, loopStateFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	volumes := []*fakeVolume{}
+	err = json.Unmarshal(data, &volumes)
+	if err != nil {
+		return fmt.Errorf("failed to parse loop provider state: %v", err)
+	}
+
+	for _, vol := range volumes {
+		if vol.ServerID != "" {
+			device, err := c.loopDevice(vol.Volume.ID)
+			if err != nil || device == "" {
+				log.Infof("Loop image of volume %s is not bound anymore", vol.Volume.ID)
+				vol.ServerID = ""
+				vol.Volume.Properties.DeviceNumber = 0
+			}
+		}
+		if vol.ServerID != "" {
+			c.servers[vol.ServerID] = "RUNNING"
+		}
+		c.volumes[vol.Volume.ID] = vol
+	}
+	return nil
+}
+
+//save is persisting volumes of the provider. The caller has to hold the lock.
+func (c *loopCloud) save() error {
+	volumes := []*fakeVolume{}
+	for _, vol := range c.volumes {
+		volumes = append(volumes, vol)
+	}
+
+	data, err := json.MarshalIndent(volumes, "", "\t")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(c.path, loopStateFile), data, metadataFileMode)
+}
+
+//loopDevice is returning the loop device an image is bound to.
+func (c *loopCloud) loopDevice(volumeID string) (string, error) {
+	stdOut, stdErr, err := c.host.Run("losetup", "-j", c.imagePath(volumeID))
+	if err != nil {
+		return "", fmt.Errorf("Error occurred while looking up loop device of %s: %s", volumeID, stdErr)
+	}
+
+	//Output format: /dev/loop0: [2049]:1234 (/path/to/image)
+	line := strings.TrimSpace(stdOut)
+	if line == "" {
+		return "", nil
+	}
+	return strings.SplitN(line, ":", 2)[0], nil
+}
+
+//CreateVolume is allocating a sparse file for a volume.
+func (c *loopCloud) CreateVolume(dcid string, request profitbricks.Volume) (*profitbricks.Volume, error) {
+	ret, err := c.fakeCloud.CreateVolume(dcid, request)
+	if err != nil {
+		return ret, err
+	}
+
+	image, err := os.OpenFile(c.imagePath(ret.ID), os.O_RDWR|os.O_CREATE|os.O_EXCL, metadataFileMode)
+	if err != nil {
+		return ret, err
+	}
+	defer image.Close()
+
+	return ret, image.Truncate(int64(request.Properties.Size) * gigabyte)
+}
+
+//UpdateVolume is updating a volume and grows its sparse file.
+func (c *loopCloud) UpdateVolume(dcid string, volid string, request profitbricks.VolumeProperties) (*profitbricks.Volume, error) {
+	ret, err := c.fakeCloud.UpdateVolume(dcid, volid, request)
+	if err != nil || request.Size == 0 {
+		return ret, err
+	}
+
+	err = os.Truncate(c.imagePath(volid), int64(request.Size)*gigabyte)
+	if err != nil {
+		return ret, err
+	}
+
+	device, err := c.loopDevice(volid)
+	if err == nil && device != "" {
+		_, stdErr, err := c.host.Run("losetup", "-c", device)
+		if err != nil {
+			return ret, fmt.Errorf("Error occurred while refreshing capacity of %s: %s", device, stdErr)
+		}
+	}
+	return ret, err
+}
+
+//DeleteVolume is deleting a volume and its sparse file. Like the Cloud API,
+//it refuses to delete a volume whose image is still bound to a loop device.
+func (c *loopCloud) DeleteVolume(dcid, volid string) (*http.Header, error) {
+	device, err := c.loopDevice(volid)
+	if err != nil {
+		return &http.Header{}, err
+	}
+	if device != "" {
+		return &http.Header{}, fakeAPIError{
+			status:  http.StatusUnprocessableEntity,
+			message: fmt.Sprintf("Volume %s is still bound to %s", volid, device),
+		}
+	}
+
+	ret, err := c.fakeCloud.DeleteVolume(dcid, volid)
+	if err != nil {
+		return ret, err
+	}
+
+	err = os.Remove(c.imagePath(volid))
+	if os.IsNotExist(err) {
+		err = nil
+	}
+	return ret, err
+}
+
+//AttachVolume is binding the sparse file of a volume to a free loop device.
+func (c *loopCloud) AttachVolume(dcid string, srvid string, volid string) (*profitbricks.Volume, error) {
+	ret, err := c.fakeCloud.AttachVolume(dcid, srvid, volid)
+	if err != nil {
+		return ret, err
+	}
+
+	device, err := c.loopDevice(volid)
+	if err != nil {
+		return ret, err
+	}
+	if device == "" {
+		stdOut, stdErr, err := c.host.Run("losetup", "--find", "--show", c.imagePath(volid))
+		if err != nil {
+			return ret, fmt.Errorf("Error occurred while attaching %s to a loop device: %s", volid, stdErr)
+		}
+		device = strings.TrimSpace(stdOut)
+	}
+	log.Infof("Volume %s is bound to %s", volid, device)
+
+	number, err := strconv.ParseInt(strings.TrimPrefix(device, loopDevicePath), 10, 64)
+	if err != nil {
+		return ret, fmt.Errorf("unexpected loop device %q", device)
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	vol := c.volumes[volid]
+	vol.Volume.Properties.Bus = loopBus
+	vol.Volume.Properties.DeviceNumber = number
+	ret.Properties.Bus = loopBus
+	ret.Properties.DeviceNumber = number
+	return ret, nil
+}
+
+//DetachVolume is releasing the loop device of a volume.
+func (c *loopCloud) DetachVolume(dcid, srvid, volid string) (*http.Header, error) {
+	ret, err := c.fakeCloud.DetachVolume(dcid, srvid, volid)
+	if err != nil {
+		return ret, err
+	}
+
+	device, err := c.loopDevice(volid)
+	if err != nil || device == "" {
+		return ret, err
+	}
+
+	_, stdErr, err := c.host.Run("losetup", "-d", device)
+	if err != nil {
+		return ret, fmt.Errorf("Error occurred while detaching %s: %s", device, stdErr)
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	c.volumes[volid].Volume.Properties.DeviceNumber = 0
+	return ret, nil
+}
+
+//GetRequestStatus is returning a request status and persists the applied changes.
+func (c *loopCloud) GetRequestStatus(path string) (*profitbricks.RequestStatus, error) {
+	ret, err := c.fakeCloud.GetRequestStatus(path)
+	if err != nil {
+		return ret, err
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	return ret, c.save()
+}
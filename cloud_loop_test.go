@@ -0,0 +1,229 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/profitbricks/profitbricks-sdk-go"
+)
+
+//newTestLoopCloud is starting a loop provider under a test directory, running commands on the host.
+func newTestLoopCloud(t *testing.T, host *fakeHost) *loopCloud {
+	c, err := newLoopCloud(testDir(t), host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+//complete is waiting for a request of the loop provider to be DONE.
+func (c *loopCloud) complete(t *testing.T, headers *http.Header) {
+	for {
+		status, err := c.GetRequestStatus(headers.Get("Location"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status.Metadata.Status == "DONE" {
+			return
+		}
+		if status.Metadata.Status == "FAILED" {
+			t.Fatalf("request failed: %s", status.Metadata.Message)
+		}
+	}
+}
+
+//createImage is creating a volume of the size and returns its id.
+func (c *loopCloud) createImage(t *testing.T, size int) string {
+	vol, err := c.CreateVolume(testDatacenterID, profitbricks.Volume{
+		Properties: profitbricks.VolumeProperties{Name: "vol", Size: size, Type: "HDD"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.complete(t, vol.Headers)
+	return vol.ID
+}
+
+//bind is making losetup report the image of a volume bound to the device, or unbound if it is empty.
+func (c *loopCloud) bind(host *fakeHost, volumeID string, device string) {
+	stdout := ""
+	if device != "" {
+		stdout = device + ": [2049]:1234 (" + c.imagePath(volumeID) + ")\n"
+	}
+	host.setOutput("losetup -j "+c.imagePath(volumeID), stdout, "", nil)
+}
+
+//imageSize is returning the size of the sparse file of a volume.
+func (c *loopCloud) imageSize(t *testing.T, volumeID string) int64 {
+	info, err := os.Stat(c.imagePath(volumeID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info.Size()
+}
+
+//contains reports whether the command line was executed on the host.
+func contains(executed []string, commandLine string) bool {
+	for _, command := range executed {
+		if command == commandLine {
+			return true
+		}
+	}
+	return false
+}
+
+func TestLoopCreateVolume(t *testing.T) {
+	host := newFakeHost()
+	c := newTestLoopCloud(t, host)
+	defer os.RemoveAll(c.path)
+
+	volumeID := c.createImage(t, 2)
+	if size := c.imageSize(t, volumeID); size != 2*gigabyte {
+		t.Errorf("image has %d bytes, expected %d", size, 2*gigabyte)
+	}
+
+	//An unbound image is only grown
+	vol, err := c.UpdateVolume(testDatacenterID, volumeID, profitbricks.VolumeProperties{Size: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.complete(t, vol.Headers)
+	if size := c.imageSize(t, volumeID); size != 3*gigabyte {
+		t.Errorf("grown image has %d bytes, expected %d", size, 3*gigabyte)
+	}
+	for _, command := range host.executed() {
+		if strings.HasPrefix(command, "losetup -c") {
+			t.Errorf("capacity of an unbound image was refreshed with %s", command)
+		}
+	}
+
+	//The loop device of a bound image is refreshed
+	c.bind(host, volumeID, "/dev/loop3")
+	if _, err := c.UpdateVolume(testDatacenterID, volumeID, profitbricks.VolumeProperties{Size: 4}); err != nil {
+		t.Fatal(err)
+	}
+	if size := c.imageSize(t, volumeID); size != 4*gigabyte {
+		t.Errorf("grown image has %d bytes, expected %d", size, 4*gigabyte)
+	}
+	if !contains(host.executed(), "losetup -c /dev/loop3") {
+		t.Errorf("capacity of the loop device was not refreshed, executed %q", host.executed())
+	}
+
+	//Volumes are restored from the state file
+	restored, err := newLoopCloud(c.path, host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vol, err := restored.GetVolume(testDatacenterID, volumeID); err != nil || vol.Properties.Size != 3 {
+		t.Errorf("restored volume is %+v: %v", vol, err)
+	}
+}
+
+func TestLoopAttachVolume(t *testing.T) {
+	host := newFakeHost()
+	c := newTestLoopCloud(t, host)
+	defer os.RemoveAll(c.path)
+
+	volumeID := c.createImage(t, 1)
+	host.setOutput("losetup --find --show "+c.imagePath(volumeID), "/dev/loop3\n", "", nil)
+	vol, err := c.AttachVolume(testDatacenterID, testServerID, volumeID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.complete(t, vol.Headers)
+	attached, err := c.GetAttachedVolume(testDatacenterID, testServerID, volumeID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attached.Properties.Bus != loopBus || attached.Properties.DeviceNumber != 3 {
+		t.Errorf("volume is attached on bus %s as device %d, expected %s and 3", attached.Properties.Bus, attached.Properties.DeviceNumber, loopBus)
+	}
+	if path, err := (Utilities{host: host}).numberedDevice(attached.Properties.Bus, attached.Properties.DeviceNumber); err != nil || path != "/dev/loop3" {
+		t.Errorf("attached volume has the device path %q: %v", path, err)
+	}
+
+	//Attachments of bound images survive a restart, the others are dropped
+	c.bind(host, volumeID, "/dev/loop3")
+	if restored, err := newLoopCloud(c.path, host); err != nil || restored.volumes[volumeID].ServerID != testServerID {
+		t.Errorf("attachment of a bound image was dropped: %v", err)
+	}
+	c.bind(host, volumeID, "")
+	if restored, err := newLoopCloud(c.path, host); err != nil || restored.volumes[volumeID].ServerID != "" {
+		t.Errorf("attachment of an unbound image was restored: %v", err)
+	}
+
+	c.bind(host, volumeID, "/dev/loop3")
+	headers, err := c.DetachVolume(testDatacenterID, testServerID, volumeID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.complete(t, headers)
+	if !contains(host.executed(), "losetup -d /dev/loop3") {
+		t.Errorf("loop device was not released, executed %q", host.executed())
+	}
+	if _, err := c.GetAttachedVolume(testDatacenterID, testServerID, volumeID); err == nil {
+		t.Error("detached volume is still attached")
+	}
+}
+
+func TestLoopAttachVolumeFails(t *testing.T) {
+	host := newFakeHost()
+	c := newTestLoopCloud(t, host)
+	defer os.RemoveAll(c.path)
+
+	volumeID := c.createImage(t, 1)
+	host.setOutput("losetup --find --show "+c.imagePath(volumeID), "", "could not find any free loop device", errors.New("exit status 1"))
+	_, err := c.AttachVolume(testDatacenterID, testServerID, volumeID)
+	if err == nil || err.Error() != "Error occurred while attaching "+volumeID+" to a loop device: could not find any free loop device" {
+		t.Errorf("failed losetup returned %v", err)
+	}
+
+	host.setOutput("losetup --find --show "+c.imagePath(volumeID), "/dev/sdb\n", "", nil)
+	if _, err := c.AttachVolume(testDatacenterID, testServerID, volumeID); err == nil {
+		t.Error("attaching to a device which is no loop device succeeded")
+	}
+}
+
+func TestLoopDeleteAttachedVolume(t *testing.T) {
+	host := newFakeHost()
+	c := newTestLoopCloud(t, host)
+	defer os.RemoveAll(c.path)
+
+	volumeID := c.createImage(t, 1)
+	host.setOutput("losetup --find --show "+c.imagePath(volumeID), "/dev/loop3\n", "", nil)
+	vol, err := c.AttachVolume(testDatacenterID, testServerID, volumeID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.complete(t, vol.Headers)
+
+	c.bind(host, volumeID, "/dev/loop3")
+	_, err = c.DeleteVolume(testDatacenterID, volumeID)
+	if apiErr, ok := err.(fakeAPIError); !ok || apiErr.HttpStatusCode() != http.StatusUnprocessableEntity {
+		t.Errorf("deleting an attached volume returned %v", err)
+	}
+	if _, err := os.Stat(c.imagePath(volumeID)); err != nil {
+		t.Errorf("image of the attached volume was removed: %v", err)
+	}
+
+	headers, err := c.DetachVolume(testDatacenterID, testServerID, volumeID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.complete(t, headers)
+	c.bind(host, volumeID, "")
+	headers, err = c.DeleteVolume(testDatacenterID, volumeID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.complete(t, headers)
+	if _, err := os.Stat(c.imagePath(volumeID)); !os.IsNotExist(err) {
+		t.Errorf("image of the deleted volume was not removed: %v", err)
+	}
+	if _, err := c.GetVolume(testDatacenterID, volumeID); err == nil {
+		t.Error("deleted volume is still listed")
+	}
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"math/rand"
+	"net"
+	"net/http"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/profitbricks/profitbricks-sdk-go"
+)
+
+//retryConfig represents how often and how long failed Cloud API calls are retried.
+type retryConfig struct {
+	retries      int
+	initialDelay time.Duration
+	maxDelay     time.Duration
+}
+
+//retryingCloud is a CloudAPI retrying calls which failed for transient reasons.
+type retryingCloud struct {
+	CloudAPI
+	config retryConfig
+	ctx    context.Context
+}
+
+//newRetryingCloud is a constructor of the retrying CloudAPI. Retries stop
+//when the context is cancelled.
+func newRetryingCloud(ctx context.Context, client CloudAPI, config retryConfig) *retryingCloud {
+	return &retryingCloud{
+		CloudAPI: client,
+		config:   config,
+		ctx:      ctx,
+	}
+}
+
+//isTransient reports whether a failed call is worth retrying.
+func isTransient(err error) bool {
+	if code, ok := apiStatusCode(err); ok {
+		switch code {
+		case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+			http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+		return false
+	}
+
+	switch err.(type) {
+	case net.Error:
+		//Connection refused, reset or timed out
+		return true
+	case *json.SyntaxError:
+		//The SDK fails to parse error pages of proxies and load balancers
+		return true
+	}
+	return false
+}
+
+//retryDelay is returning a jittered delay. Delays requested with Retry-After
+//headers are already waited for by the SDK.
+func retryDelay(delay time.Duration) time.Duration {
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+//retry is calling the function till it succeeds, fails permanently or runs out of retries.
+func (c *retryingCloud) retry(method string, call func(attempt int) error) error {
+	delay := c.config.initialDelay
+	for attempt := 1; ; attempt++ {
+		err := call(attempt)
+		if err == nil || attempt > c.config.retries || !isTransient(err) {
+			return err
+		}
+
+		wait := retryDelay(delay)
+		log.Warnf("%s failed, retrying in %v (retry %d of %d): %v", method, wait, attempt, c.config.retries, err)
+		timer := time.NewTimer(wait)
+		select {
+		case <-c.ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+
+		delay *= 2
+		if delay > c.config.maxDelay {
+			delay = c.config.maxDelay
+		}
+	}
+}
+
+//ListVolumes is listing volumes of the datacenter.
+func (c *retryingCloud) ListVolumes(dcid string) (ret *profitbricks.Volumes, err error) {
+	err = c.retry("ListVolumes", func(int) error {
+		ret, err = c.CloudAPI.ListVolumes(dcid)
+		return err
+	})
+	return ret, err
+}
+
+//GetVolume is getting a volume.
+func (c *retryingCloud) GetVolume(dcid string, volumeID string) (ret *profitbricks.Volume, err error) {
+	err = c.retry("GetVolume", func(int) error {
+		ret, err = c.CloudAPI.GetVolume(dcid, volumeID)
+		return err
+	})
+	return ret, err
+}
+
+//CreateVolume is creating a volume. A failed call might have created the
+//volume anyway, so it is looked up by name before creating it again. A found
+//volume has no request to wait for, its state has to be polled instead.
+func (c *retryingCloud) CreateVolume(dcid string, request profitbricks.Volume) (ret *profitbricks.Volume, err error) {
+	err = c.retry("CreateVolume", func(attempt int) error {
+		if attempt > 1 {
+			var volumes *profitbricks.Volumes
+			volumes, err = c.CloudAPI.ListVolumes(dcid)
+			if err != nil {
+				return err
+			}
+			for _, v := range volumes.Items {
+				if v.Properties.Name == request.Properties.Name {
+					log.Infof("Volume %s was created by a failed call", request.Properties.Name)
+					ret = &v
+					ret.Headers = &http.Header{}
+					return nil
+				}
+			}
+		}
+
+		ret, err = c.CloudAPI.CreateVolume(dcid, request)
+		return err
+	})
+	return ret, err
+}
+
+//UpdateVolume is updating volume properties.
+func (c *retryingCloud) UpdateVolume(dcid string, volid string, request profitbricks.VolumeProperties) (ret *profitbricks.Volume, err error) {
+	err = c.retry("UpdateVolume", func(int) error {
+		ret, err = c.CloudAPI.UpdateVolume(dcid, volid, request)
+		return err
+	})
+	return ret, err
+}
+
+//DeleteVolume is deleting a volume. A retried call not finding the volume
+//means a failed call has already deleted it.
+func (c *retryingCloud) DeleteVolume(dcid, volid string) (ret *http.Header, err error) {
+	err = c.retry("DeleteVolume", func(attempt int) error {
+		ret, err = c.CloudAPI.DeleteVolume(dcid, volid)
+		if attempt > 1 && isNotFound(err) {
+			ret = &http.Header{}
+			return nil
+		}
+		return err
+	})
+	return ret, err
+}
+
+//AttachVolume is attaching a volume to a server. A failed call might have
+//attached the volume anyway, so attachments are checked before attaching it again.
+func (c *retryingCloud) AttachVolume(dcid string, srvid string, volid string) (ret *profitbricks.Volume, err error) {
+	err = c.retry("AttachVolume", func(attempt int) error {
+		if attempt > 1 {
+			var volumes *profitbricks.Volumes
+			volumes, err = c.CloudAPI.ListAttachedVolumes(dcid, srvid)
+			if err != nil {
+				return err
+			}
+			for _, v := range volumes.Items {
+				if v.ID == volid {
+					log.Infof("Volume %s was attached by a failed call", volid)
+					ret = &v
+					ret.Headers = &http.Header{}
+					return nil
+				}
+			}
+		}
+
+		ret, err = c.CloudAPI.AttachVolume(dcid, srvid, volid)
+		return err
+	})
+	return ret, err
+}
+
+//DetachVolume is detaching a volume from a server. A retried call not finding
+//the attachment means a failed call has already detached it.
+func (c *retryingCloud) DetachVolume(dcid, srvid, volid string) (ret *http.Header, err error) {
+	err = c.retry("DetachVolume", func(attempt int) error {
+		ret, err = c.CloudAPI.DetachVolume(dcid, srvid, volid)
+		if attempt > 1 && isNotFound(err) {
+			ret = &http.Header{}
+			return nil
+		}
+		return err
+	})
+	return ret, err
+}
+
+//ListAttachedVolumes is listing volumes attached to a server.
+func (c *retryingCloud) ListAttachedVolumes(dcid, srvid string) (ret *profitbricks.Volumes, err error) {
+	err = c.retry("ListAttachedVolumes", func(int) error {
+		ret, err = c.CloudAPI.ListAttachedVolumes(dcid, srvid)
+		return err
+	})
+	return ret, err
+}
+
+//GetAttachedVolume is getting a volume attached to a server.
+func (c *retryingCloud) GetAttachedVolume(dcid, srvid, volid string) (ret *profitbricks.Volume, err error) {
+	err = c.retry("GetAttachedVolume", func(int) error {
+		ret, err = c.CloudAPI.GetAttachedVolume(dcid, srvid, volid)
+		return err
+	})
+	return ret, err
+}
+
+//ListServers is listing servers of the datacenter.
+func (c *retryingCloud) ListServers(dcid string) (ret *profitbricks.Servers, err error) {
+	err = c.retry("ListServers", func(int) error {
+		ret, err = c.CloudAPI.ListServers(dcid)
+		return err
+	})
+	return ret, err
+}
+
+//GetServer is getting a server.
+func (c *retryingCloud) GetServer(dcid, srvid string) (ret *profitbricks.Server, err error) {
+	err = c.retry("GetServer", func(int) error {
+		ret, err = c.CloudAPI.GetServer(dcid, srvid)
+		return err
+	})
+	return ret, err
+}
+
+//ListSnapshots is listing snapshots.
+func (c *retryingCloud) ListSnapshots() (ret *profitbricks.Snapshots, err error) {
+	err = c.retry("ListSnapshots", func(int) error {
+		ret, err = c.CloudAPI.ListSnapshots()
+		return err
+	})
+	return ret, err
+}
+
+//GetSnapshot is getting a snapshot.
+func (c *retryingCloud) GetSnapshot(snapshotID string) (ret *profitbricks.Snapshot, err error) {
+	err = c.retry("GetSnapshot", func(int) error {
+		ret, err = c.CloudAPI.GetSnapshot(snapshotID)
+		return err
+	})
+	return ret, err
+}
+
+//GetRequestStatus is returning the status of a request.
+func (c *retryingCloud) GetRequestStatus(path string) (ret *profitbricks.RequestStatus, err error) {
+	err = c.retry("GetRequestStatus", func(int) error {
+		ret, err = c.CloudAPI.GetRequestStatus(path)
+		return err
+	})
+	return ret, err
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/profitbricks/profitbricks-sdk-go"
+)
+
+//throttledError is reported for calls the Cloud API rejected with 429 Too Many
+//Requests without a Retry-After header.
+type throttledError struct {
+	method string
+}
+
+//Error is returning a message of the error.
+func (e throttledError) Error() string {
+	return fmt.Sprintf("%s was throttled by the Cloud API: HTTP Status: %d", e.method, http.StatusTooManyRequests)
+}
+
+//HttpStatusCode is returning a http status of the error.
+func (e throttledError) HttpStatusCode() int {
+	return http.StatusTooManyRequests
+}
+
+//sdkCloud is the CloudAPI of the SDK client. The SDK itself waits and repeats
+//calls answered with 429 and a Retry-After header, but returns an empty result
+//without an error if the header is missing, which sdkCloud reports as error.
+type sdkCloud struct {
+	client *profitbricks.Client
+}
+
+//newSDKCloud is a constructor of the CloudAPI of the SDK client.
+func newSDKCloud(client *profitbricks.Client) *sdkCloud {
+	return &sdkCloud{client: client}
+}
+
+//throttled is returning a throttledError for successful calls without
+//response headers, which every answered call has.
+func throttled(method string, headers *http.Header, err error) error {
+	if err == nil && (headers == nil || len(*headers) == 0) {
+		return throttledError{method: method}
+	}
+	return err
+}
+
+//ListVolumes is listing volumes of the datacenter.
+func (c *sdkCloud) ListVolumes(dcid string) (*profitbricks.Volumes, error) {
+	ret, err := c.client.ListVolumes(dcid)
+	return ret, throttled("ListVolumes", ret.Headers, err)
+}
+
+//GetVolume is getting a volume.
+func (c *sdkCloud) GetVolume(dcid string, volumeID string) (*profitbricks.Volume, error) {
+	ret, err := c.client.GetVolume(dcid, volumeID)
+	return ret, throttled("GetVolume", ret.Headers, err)
+}
+
+//CreateVolume is creating a volume.
+func (c *sdkCloud) CreateVolume(dcid string, request profitbricks.Volume) (*profitbricks.Volume, error) {
+	ret, err := c.client.CreateVolume(dcid, request)
+	return ret, throttled("CreateVolume", ret.Headers, err)
+}
+
+//UpdateVolume is updating volume properties.
+func (c *sdkCloud) UpdateVolume(dcid string, volid string, request profitbricks.VolumeProperties) (*profitbricks.Volume, error) {
+	ret, err := c.client.UpdateVolume(dcid, volid, request)
+	return ret, throttled("UpdateVolume", ret.Headers, err)
+}
+
+//DeleteVolume is deleting a volume.
+func (c *sdkCloud) DeleteVolume(dcid, volid string) (*http.Header, error) {
+	ret, err := c.client.DeleteVolume(dcid, volid)
+	return ret, throttled("DeleteVolume", ret, err)
+}
+
+//AttachVolume is attaching a volume to a server.
+func (c *sdkCloud) AttachVolume(dcid string, srvid string, volid string) (*profitbricks.Volume, error) {
+	ret, err := c.client.AttachVolume(dcid, srvid, volid)
+	return ret, throttled("AttachVolume", ret.Headers, err)
+}
+
+//DetachVolume is detaching a volume from a server.
+func (c *sdkCloud) DetachVolume(dcid, srvid, volid string) (*http.Header, error) {
+	ret, err := c.client.DetachVolume(dcid, srvid, volid)
+	return ret, throttled("DetachVolume", ret, err)
+}
+
+//ListAttachedVolumes is listing volumes attached to a server.
+func (c *sdkCloud) ListAttachedVolumes(dcid, srvid string) (*profitbricks.Volumes, error) {
+	ret, err := c.client.ListAttachedVolumes(dcid, srvid)
+	return ret, throttled("ListAttachedVolumes", ret.Headers, err)
+}
+
+//GetAttachedVolume is getting a volume attached to a server.
+func (c *sdkCloud) GetAttachedVolume(dcid, srvid, volid string) (*profitbricks.Volume, error) {
+	ret, err := c.client.GetAttachedVolume(dcid, srvid, volid)
+	return ret, throttled("GetAttachedVolume", ret.Headers, err)
+}
+
+//ListServers is listing servers of the datacenter.
+func (c *sdkCloud) ListServers(dcid string) (*profitbricks.Servers, error) {
+	ret, err := c.client.ListServers(dcid)
+	return ret, throttled("ListServers", ret.Headers, err)
+}
+
+//GetServer is getting a server.
+func (c *sdkCloud) GetServer(dcid, srvid string) (*profitbricks.Server, error) {
+	ret, err := c.client.GetServer(dcid, srvid)
+	return ret, throttled("GetServer", ret.Headers, err)
+}
+
+//ListSnapshots is listing snapshots.
+func (c *sdkCloud) ListSnapshots() (*profitbricks.Snapshots, error) {
+	ret, err := c.client.ListSnapshots()
+	return ret, throttled("ListSnapshots", ret.Headers, err)
+}
+
+//GetSnapshot is getting a snapshot.
+func (c *sdkCloud) GetSnapshot(snapshotID string) (*profitbricks.Snapshot, error) {
+	ret, err := c.client.GetSnapshot(snapshotID)
+	return ret, throttled("GetSnapshot", ret.Headers, err)
+}
+
+//GetRequestStatus is returning the status of a request.
+func (c *sdkCloud) GetRequestStatus(path string) (*profitbricks.RequestStatus, error) {
+	ret, err := c.client.GetRequestStatus(path)
+	return ret, throttled("GetRequestStatus", ret.Headers, err)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/profitbricks/profitbricks-sdk-go"
+)
+
+//throttlingAPI is answering the first calls with 429 without Retry-After header.
+type throttlingAPI struct {
+	sync.Mutex
+	throttled int
+	calls     int
+}
+
+//ServeHTTP is answering a call with a volume once it is not throttled anymore.
+func (a *throttlingAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.Lock()
+	a.calls++
+	throttled := a.calls <= a.throttled
+	a.Unlock()
+
+	if throttled {
+		w.WriteHeader(http.StatusTooManyRequests)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{"id":"volume","properties":{"name":"vol","size":1}}`))
+}
+
+//newThrottlingCloud is returning the CloudAPI of the SDK client calling the API.
+func newThrottlingCloud(api *throttlingAPI) (*sdkCloud, func()) {
+	server := httptest.NewServer(api)
+	client := profitbricks.NewClient("user", "password")
+	client.SetURL(server.URL)
+	return newSDKCloud(client), server.Close
+}
+
+func TestSDKCloudThrottled(t *testing.T) {
+	cloud, closeServer := newThrottlingCloud(&throttlingAPI{throttled: 1})
+	defer closeServer()
+
+	_, err := cloud.GetVolume("datacenter", "volume")
+	if code, ok := apiStatusCode(err); !ok || code != http.StatusTooManyRequests || !isTransient(err) {
+		t.Fatalf("throttled call returned %v", err)
+	}
+
+	vol, err := cloud.GetVolume("datacenter", "volume")
+	if err != nil || vol.ID != "volume" {
+		t.Fatalf("returned %v: %v", vol, err)
+	}
+}
+
+func TestRetryThrottled(t *testing.T) {
+	api := &throttlingAPI{throttled: 2}
+	cloud, closeServer := newThrottlingCloud(api)
+	defer closeServer()
+
+	retrying := newRetryingCloud(context.Background(), cloud, retryConfig{retries: 3, initialDelay: time.Millisecond, maxDelay: time.Millisecond})
+	vol, err := retrying.GetVolume("datacenter", "volume")
+	if err != nil || vol.ID != "volume" {
+		t.Fatalf("returned %v: %v", vol, err)
+	}
+	api.Lock()
+	defer api.Unlock()
+	if api.calls != 3 {
+		t.Errorf("called the API %d times, expected 3", api.calls)
+	}
+}
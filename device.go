@@ -0,0 +1,278 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/profitbricks/profitbricks-sdk-go"
+)
+
+//Constances used by device discovery.
+const (
+	sysBlockPath   = "/sys/block"
+	deviceByIDPath = "/dev/disk/by-id"
+	deviceByPath   = "/dev/disk/by-path"
+	//Buses volumes are attached to by the Cloud API.
+	busVirtio = "VIRTIO"
+	busIDE    = "IDE"
+	//serialLength is the length virtio disk serials are truncated to.
+	serialLength = 20
+)
+
+//busPaths maps the buses to the part the by-path links of their devices contain.
+var busPaths = map[string]string{
+	busVirtio: "virtio-pci-",
+	busIDE:    "-ata-",
+}
+
+//virtioSlot matches the PCI bus and slot in the by-path link of a virtio disk.
+var virtioSlot = regexp.MustCompile(`virtio-pci-([0-9a-f]{4}:[0-9a-f]{2}):([0-9a-f]{2})\.[0-7]$`)
+
+//driveLetters is returning the letters the kernel names the disk with the
+//index after, e.g. a for 0, z for 25 and aa for 26.
+func driveLetters(index int64) string {
+	letters := ""
+	for index >= 0 {
+		letters = string(rune('a'+index%26)) + letters
+		index = index/26 - 1
+	}
+	return letters
+}
+
+//ideByPath is returning the suffixes of the by-path links of the IDE disk with
+//the device number, master and slave of the primary channel being 1 and 2.
+func ideByPath(number int64) []string {
+	port, unit := (number-1)/2+1, (number-1)%2
+	suffixes := []string{fmt.Sprintf("-ata-%d.%d", port, unit)}
+	//udev before version 233 names the master of a channel without its unit
+	if unit == 0 {
+		suffixes = append(suffixes, fmt.Sprintf("-ata-%d", port))
+	}
+	return suffixes
+}
+
+//checkDeviceSlot is checking that the by-path link of a device names the slot
+//of the device number. IDE disks have to be on the ATA port of the number.
+//Virtio disks are placed in consecutive PCI slots after the boot volume, the
+//slot is only checked if the boot volume is linked on the same PCI bus.
+func (m Utilities) checkDeviceSlot(devicePath string, bus string, number int64, link string) error {
+	name := filepath.Base(link)
+	switch bus {
+	case busIDE:
+		for _, suffix := range ideByPath(number) {
+			if strings.HasSuffix(name, suffix) {
+				return nil
+			}
+		}
+		return fmt.Errorf("device %s is linked as %s, it is not on the ATA port of device %d", devicePath, link, number)
+	case busVirtio:
+		slot := virtioSlot.FindStringSubmatch(name)
+		if slot == nil {
+			return nil
+		}
+		for _, bootLink := range m.DeviceLinks(deviceByPath, "/dev/vd"+driveLetters(0)) {
+			bootSlot := virtioSlot.FindStringSubmatch(filepath.Base(bootLink))
+			if bootSlot == nil || bootSlot[1] != slot[1] {
+				continue
+			}
+			first, _ := strconv.ParseInt(bootSlot[2], 16, 64)
+			actual, _ := strconv.ParseInt(slot[2], 16, 64)
+			if actual != first+number-1 {
+				return fmt.Errorf("device %s is linked as %s, expected PCI slot %02x for device %d after the boot volume in slot %02x", devicePath, link, first+number-1, number, first)
+			}
+		}
+	}
+	return nil
+}
+
+//DeviceLinks is returning the links in the directory resolving to the device.
+func (m Utilities) DeviceLinks(dir string, devicePath string) []string {
+	links := []string{}
+	names, err := m.host.ReadDir(dir)
+	if err != nil {
+		return links
+	}
+	for _, name := range names {
+		link := filepath.Join(dir, name)
+		target, err := m.host.EvalSymlinks(link)
+		if err == nil && target == devicePath {
+			links = append(links, link)
+		}
+	}
+	return links
+}
+
+//serialDevice is returning the device a by-id link carrying the serial of
+//the volume resolves to, or an empty string if there is none.
+func (m Utilities) serialDevice(volumeID string) string {
+	serial := volumeID
+	if len(serial) > serialLength {
+		serial = serial[:serialLength]
+	}
+
+	names, err := m.host.ReadDir(deviceByIDPath)
+	if err != nil {
+		return ""
+	}
+	for _, name := range names {
+		if !strings.Contains(name, serial) || strings.Contains(name, "-part") {
+			continue
+		}
+		target, err := m.host.EvalSymlinks(filepath.Join(deviceByIDPath, name))
+		if err == nil {
+			return target
+		}
+	}
+	return ""
+}
+
+//numberedDevice is returning the device attached with the number to the bus,
+//or an empty string if udev has not linked it yet. Virtio disks are named
+//after their device number, vda being the boot volume with device number 1,
+//IDE disks are found by their ATA port.
+func (m Utilities) numberedDevice(bus string, number int64) (string, error) {
+	switch bus {
+	case busVirtio:
+		return "/dev/vd" + driveLetters(number-1), nil
+	case busIDE:
+		names, err := m.host.ReadDir(deviceByPath)
+		if err != nil {
+			return "", nil
+		}
+		for _, name := range names {
+			for _, suffix := range ideByPath(number) {
+				target, err := m.host.EvalSymlinks(filepath.Join(deviceByPath, name))
+				if strings.HasSuffix(name, suffix) && err == nil {
+					return target, nil
+				}
+			}
+		}
+		return "", nil
+	case loopBus:
+		return fmt.Sprintf("%s%d", loopDevicePath, number), nil
+	}
+	return "", fmt.Errorf("unsupported bus %q", bus)
+}
+
+//AttachedDevice is returning the device path of an attached volume, found by
+//the serial of the volume or by its bus and device number, and whether the
+//kernel and udev have already set the device up. Devices on the virtio and
+//IDE buses have to be linked under /dev/disk/by-path on their bus, in the slot
+//of their device number unless they were found by the serial.
+func (m Utilities) AttachedDevice(volumeID string, bus string, number int64) (string, bool, error) {
+	bus = strings.ToUpper(bus)
+	if number <= 0 && bus != loopBus {
+		return "", false, fmt.Errorf("invalid device number %d on bus %q", number, bus)
+	}
+
+	devicePath, err := m.numberedDevice(bus, number)
+	if err != nil {
+		return "", false, err
+	}
+	//The serial identifies the device even if the kernel named it differently
+	bySerial := m.serialDevice(volumeID)
+	if bySerial != "" {
+		if bySerial != devicePath {
+			log.Warnf("Device %s of volume %s has its serial, expected %s for device %d on bus %s", bySerial, volumeID, devicePath, number, bus)
+		}
+		devicePath = bySerial
+	}
+	if devicePath == "" || !m.host.Exists(filepath.Join(sysBlockPath, filepath.Base(devicePath))) || !m.host.Exists(devicePath) {
+		return devicePath, false, nil
+	}
+
+	busPath, ok := busPaths[bus]
+	if !ok {
+		return devicePath, true, nil
+	}
+	links := m.DeviceLinks(deviceByPath, devicePath)
+	if len(links) == 0 {
+		return devicePath, false, nil
+	}
+	for _, link := range links {
+		if !strings.Contains(filepath.Base(link), busPath) {
+			continue
+		}
+		//A device found by the serial is not necessarily in the slot of the number
+		if bySerial == "" {
+			if err := m.checkDeviceSlot(devicePath, bus, number, link); err != nil {
+				return "", false, err
+			}
+		}
+		return devicePath, true, nil
+	}
+	return "", false, fmt.Errorf("device %s is linked as %v, it is not on the %s bus", devicePath, links, bus)
+}
+
+//resolveDevice is waiting till the device of a volume attached to this server
+//is set up and returns its path. The device is cross-checked against the size
+//of the volume, so a wrongly named device is never formatted.
+func (d *Driver) resolveDevice(name string, volumeID string) (string, error) {
+	attached, err := d.client.GetAttachedVolume(d.datacenterID, d.serverID, volumeID)
+	if err != nil {
+		return "", fmt.Errorf("failed to get the attachment of volume %q: %v", name, err)
+	}
+	return d.waitForDevice(name, attached)
+}
+
+//volumeDevice is resolving the device of a volume attached for mounting,
+//which has to hold the filesystem stamped with the ID of the volume.
+func (d *Driver) volumeDevice(name string, volumeID string) (string, error) {
+	devicePath, err := d.resolveDevice(name, volumeID)
+	if err != nil {
+		return "", err
+	}
+
+	signature, err := d.utilities.ProbeDevice(devicePath)
+	if err != nil {
+		return "", err
+	}
+	if signature["UUID"] != volumeID {
+		return "", fmt.Errorf("Device %s of volume %q holds %v, expected the filesystem UUID %s", devicePath, name, signature, volumeID)
+	}
+	return devicePath, nil
+}
+
+//waitForDevice is polling for the device of an attached volume till it
+//appears with the size of the volume, the device times out or the driver is
+//shut down.
+func (d *Driver) waitForDevice(name string, attached *profitbricks.Volume) (string, error) {
+	bus, number := attached.Properties.Bus, attached.Properties.DeviceNumber
+	log.Infof("Waiting for device %d on bus %s of volume %s", number, bus, name)
+
+	devicePath := ""
+	subject := fmt.Sprintf("%d on bus %s of volume %q", number, bus, name)
+	err := d.poll(operationDevice, subject, deviceReady, func() (string, error) {
+		var ready bool
+		var err error
+		devicePath, ready, err = d.utilities.AttachedDevice(attached.ID, bus, number)
+		if err != nil {
+			return "", fmt.Errorf("Device of volume %q could not be resolved: %v", name, err)
+		}
+		if !ready {
+			return fmt.Sprintf("%q not set up by udev", devicePath), nil
+		}
+
+		size, err := d.utilities.DeviceSize(devicePath)
+		switch {
+		case err != nil:
+			return fmt.Sprintf("%q %v", devicePath, err), nil
+		case size == 0:
+			return fmt.Sprintf("%q reports no size", devicePath), nil
+		case attached.Properties.Size > 0 && size != uint64(attached.Properties.Size)*gigabyte:
+			return "", fmt.Errorf("Device %s has %d bytes, volume %q has %d GB, refusing to use it", devicePath, size, name, attached.Properties.Size)
+		}
+		return deviceReady, nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	log.Infof("Volume %s is device %s, links: %v", name, devicePath,
+		append(d.utilities.DeviceLinks(deviceByIDPath, devicePath), d.utilities.DeviceLinks(deviceByPath, devicePath)...))
+	return devicePath, nil
+}
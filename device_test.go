@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/profitbricks/profitbricks-sdk-go"
+)
+
+//setDevice is making a block device known to sysfs and /dev of the fake host.
+func setDevice(host *fakeHost, name string, byPath string, size int) {
+	host.setFile("/dev/"+name, "")
+	host.setFile(sysBlockPath+"/"+name+"/size", "")
+	if byPath != "" {
+		host.setLink(deviceByPath+"/"+byPath, "../../"+name)
+	}
+	host.setOutput("blockdev --getsize64 /dev/"+name, strconv.Itoa(size*gigabyte)+"\n", "", nil)
+}
+
+func TestDriveLetters(t *testing.T) {
+	for index, expected := range map[int64]string{0: "a", 1: "b", 25: "z", 26: "aa", 27: "ab", 701: "zz", 702: "aaa"} {
+		if letters := driveLetters(index); letters != expected {
+			t.Errorf("index %d is named %s, expected %s", index, letters, expected)
+		}
+	}
+}
+
+func TestAttachedVirtioDevice(t *testing.T) {
+	host := newFakeHost()
+	utilities := NewUtilitiesWithHost(host)
+	setDevice(host, "vda", "virtio-pci-0000:00:05.0", 10)
+
+	_, ready, err := utilities.AttachedDevice("volume", "VIRTIO", 2)
+	if err != nil || ready {
+		t.Fatalf("missing device is ready %v: %v", ready, err)
+	}
+
+	//udev did not link the device yet
+	host.setFile("/dev/vdb", "")
+	host.setFile(sysBlockPath+"/vdb/size", "")
+	_, ready, err = utilities.AttachedDevice("volume", "VIRTIO", 2)
+	if err != nil || ready {
+		t.Fatalf("unlinked device is ready %v: %v", ready, err)
+	}
+
+	host.setLink(deviceByPath+"/virtio-pci-0000:00:06.0", "/dev/vdb")
+	devicePath, ready, err := utilities.AttachedDevice("volume", "virtio", 2)
+	if err != nil || !ready || devicePath != "/dev/vdb" {
+		t.Fatalf("resolved %s ready %v: %v", devicePath, ready, err)
+	}
+}
+
+func TestAttachedDeviceOnOtherBus(t *testing.T) {
+	host := newFakeHost()
+	setDevice(host, "vdb", "pci-0000:00:01.1-ata-1.0", 1)
+
+	_, _, err := NewUtilitiesWithHost(host).AttachedDevice("volume", busVirtio, 2)
+	if err == nil || !strings.Contains(err.Error(), "not on the VIRTIO bus") {
+		t.Errorf("device linked on the ata bus was accepted: %v", err)
+	}
+}
+
+func TestAttachedDeviceInOtherSlot(t *testing.T) {
+	host := newFakeHost()
+	setDevice(host, "vda", "virtio-pci-0000:00:05.0", 10)
+	//Device 2 belongs in slot 06, the disk in slot 08 is another volume
+	setDevice(host, "vdb", "virtio-pci-0000:00:08.0", 1)
+
+	_, _, err := NewUtilitiesWithHost(host).AttachedDevice("volume", busVirtio, 2)
+	if err == nil || !strings.Contains(err.Error(), "expected PCI slot 06") {
+		t.Errorf("device in the slot of another device number was accepted: %v", err)
+	}
+}
+
+func TestAttachedIDEDevice(t *testing.T) {
+	host := newFakeHost()
+	utilities := NewUtilitiesWithHost(host)
+	setDevice(host, "sda", "pci-0000:00:01.1-ata-1.0", 10)
+	setDevice(host, "sdc", "pci-0000:00:01.1-ata-1.1", 1)
+	//Older udev names masters without their unit
+	setDevice(host, "sdb", "pci-0000:00:01.1-ata-2", 1)
+
+	for number, expected := range map[int64]string{2: "/dev/sdc", 3: "/dev/sdb"} {
+		devicePath, ready, err := utilities.AttachedDevice("volume", busIDE, number)
+		if err != nil || !ready || devicePath != expected {
+			t.Errorf("device %d resolved to %s ready %v: %v, expected %s", number, devicePath, ready, err, expected)
+		}
+	}
+
+	_, ready, err := utilities.AttachedDevice("volume", busIDE, 4)
+	if err != nil || ready {
+		t.Errorf("missing IDE device is ready %v: %v", ready, err)
+	}
+}
+
+func TestAttachedDeviceBySerial(t *testing.T) {
+	host := newFakeHost()
+	volumeID := "9a3bd7b4-5e2c-4e1f-8b0a-1c2d3e4f5a6b"
+	setDevice(host, "vda", "virtio-pci-0000:00:05.0", 10)
+	setDevice(host, "vdc", "virtio-pci-0000:00:07.0", 1)
+	host.setLink(deviceByIDPath+"/virtio-"+volumeID[:serialLength], "../../vdc")
+	host.setLink(deviceByIDPath+"/virtio-"+volumeID[:serialLength]+"-part1", "../../vdc1")
+
+	devicePath, ready, err := NewUtilitiesWithHost(host).AttachedDevice(volumeID, busVirtio, 2)
+	if err != nil || !ready || devicePath != "/dev/vdc" {
+		t.Errorf("resolved %s ready %v: %v, expected /dev/vdc", devicePath, ready, err)
+	}
+}
+
+func TestAttachedDeviceUnsupportedBus(t *testing.T) {
+	utilities := NewUtilitiesWithHost(newFakeHost())
+	for _, bus := range []string{"NVME", "SCSI", ""} {
+		if _, _, err := utilities.AttachedDevice("volume", bus, 2); err == nil {
+			t.Errorf("bus %q was accepted", bus)
+		}
+	}
+	if _, _, err := utilities.AttachedDevice("volume", busVirtio, 0); err == nil {
+		t.Error("device number 0 was accepted")
+	}
+}
+
+//deviceDriver is returning a driver resolving devices on the fake host.
+func deviceDriver(host *fakeHost) *Driver {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Driver{
+		utilities: NewUtilitiesWithHost(host),
+		polling: map[string]*pollConfig{
+			operationDevice: {initialInterval: time.Millisecond, maxInterval: time.Millisecond, timeout: 50 * time.Millisecond},
+		},
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+//attachment is returning a volume attached with the device number to the bus.
+func attachment(bus string, number int64, size int) *profitbricks.Volume {
+	return &profitbricks.Volume{
+		ID:         "volume",
+		Properties: profitbricks.VolumeProperties{Bus: bus, DeviceNumber: number, Size: size},
+	}
+}
+
+func TestWaitForDevice(t *testing.T) {
+	host := newFakeHost()
+	setDevice(host, "vdb", "virtio-pci-0000:00:06.0", 2)
+	d := deviceDriver(host)
+	defer d.cancel()
+
+	devicePath, err := d.waitForDevice("vol", attachment(busVirtio, 2, 2))
+	if err != nil || devicePath != "/dev/vdb" {
+		t.Errorf("resolved %s: %v", devicePath, err)
+	}
+
+	_, err = d.waitForDevice("vol", attachment(busVirtio, 2, 5))
+	if err == nil || !strings.Contains(err.Error(), "refusing to use it") {
+		t.Errorf("device of another size was accepted: %v", err)
+	}
+
+	_, err = d.waitForDevice("vol", attachment(busVirtio, 3, 2))
+	if err == nil || !strings.Contains(err.Error(), "did not finish within") {
+		t.Errorf("missing device did not time out: %v", err)
+	}
+
+	d.cancel()
+	_, err = d.waitForDevice("vol", attachment(busVirtio, 3, 2))
+	if err == nil || !strings.Contains(err.Error(), "cancelled on shutdown") {
+		t.Errorf("waiting for the missing device was not cancelled: %v", err)
+	}
+}
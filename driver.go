@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -32,17 +33,34 @@ type Driver struct {
 	serverID     string
 	size         int
 	diskType     string
+	//filesystem is the type new volumes are formatted with unless requested otherwise.
+	filesystem string
+	//mkfsOptions and mountOptions are the defaults for volumes of the default
+	//filesystem requesting none.
+	mkfsOptions  string
+	mountOptions string
 	utilities    *Utilities
+	//RWMutex guards the volumes map only, operations lock their volume.
 	sync.RWMutex
 	volumes map[string]*volumeState
-	client  *profitbricks.Client
-}
-
-//VolumeState represents a volume state in the  metadata.
-type volumeState struct {
-	VolumeID   string
-	MountPoint string
-	DeviceName string
+	client  CloudAPI
+	locks   *volumeLocks
+	remote  *volumeCache
+	//polling holds the polling configuration per operation type.
+	polling map[string]*pollConfig
+	//ctx is cancelled when the driver is shut down.
+	ctx    context.Context
+	cancel context.CancelFunc
+	//operations tracks volume operations in progress.
+	operations sync.WaitGroup
+	//missingVolumePolicy decides what happens to volumes deleted from the datacenter.
+	missingVolumePolicy string
+	//takeoverPolicy decides when volumes attached to other servers are taken over.
+	takeoverPolicy string
+	//leaseDuration is how long mounted volumes are leased to the server, 0 disables leases.
+	leaseDuration time.Duration
+	//usage decides how the filesystem usage of mounted volumes is reported.
+	usage usageConfig
 }
 
 //ProfitBricksDriver is a constuctor of the driver.
@@ -56,6 +74,11 @@ func ProfitBricksDriver(utilities *Utilities, args CommandLineArgs) (*Driver, er
 		client.SetURL(*args.profitbricksEndpoint)
 	}
 
+	return NewDriver(newSDKCloud(client), utilities, args)
+}
+
+//NewDriver is a constructor of the driver using the provided Cloud API implementation.
+func NewDriver(client CloudAPI, utilities *Utilities, args CommandLineArgs) (*Driver, error) {
 	err := os.MkdirAll(*args.metadataPath, metadataDirMode)
 	if err != nil {
 		return nil, err
@@ -66,48 +89,164 @@ func ProfitBricksDriver(utilities *Utilities, args CommandLineArgs) (*Driver, er
 		return nil, err
 	}
 
-	serverID, err := utilities.GetServerID()
-
-	if err != nil {
-		log.Error(err)
-		return nil, err
+	serverID := *args.serverID
+	if serverID == "" {
+		serverID, err = utilities.GetServerID()
+		if err != nil {
+			log.Error(err)
+			return nil, err
+		}
 	}
 
 	log.Info("Server ID:", strings.ToLower(serverID))
 
+	takeoverPolicy := takeoverStopped
+	if args.takeoverPolicy != nil {
+		takeoverPolicy = *args.takeoverPolicy
+	}
+
+	filesystem := defaultFilesystem
+	if args.filesystem != nil {
+		filesystem = *args.filesystem
+	}
+
+	mkfsOptions, mountOptions := "", ""
+	if args.mkfsOptions != nil {
+		mkfsOptions = *args.mkfsOptions
+	}
+	if args.mountOptions != nil {
+		mountOptions = *args.mountOptions
+	}
+
+	leaseDuration := defaultLeaseDuration
+	if args.leaseDuration != nil {
+		leaseDuration = *args.leaseDuration
+	}
+
+	usage := usageConfig{
+		interval:        defaultUsageInterval,
+		warningPercent:  defaultUsageWarningPercent,
+		criticalPercent: defaultUsageCriticalPercent,
+	}
+	if args.usage != nil {
+		usage = *args.usage
+	}
+
+	volumeCacheTTL := defaultVolumeCacheTTL
+	if args.volumeCacheTTL != nil {
+		volumeCacheTTL = *args.volumeCacheTTL
+	}
+
+	polling := defaultPollConfigs()
+	for operation, config := range args.polling {
+		polling[operation] = config
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	if args.retry != nil && args.retry.retries > 0 {
+		client = newRetryingCloud(ctx, client, *args.retry)
+	}
+
 	driver := &Driver{
 		datacenterID: *args.datacenterID,
 		serverID:     strings.ToLower(serverID),
 		size:         *args.size,
 		diskType:     *args.diskType,
+		filesystem:   filesystem,
+		mkfsOptions:  mkfsOptions,
+		mountOptions: mountOptions,
 		volumes:      make(map[string]*volumeState),
 		metadataPath: *args.metadataPath,
 		utilities:    utilities,
 		mountPath:    *args.mountPath,
 		client:       client,
+		locks:        newVolumeLocks(),
+		remote:       newVolumeCache(volumeCacheTTL),
+		polling:      polling,
+		ctx:          ctx,
+		cancel:       cancel,
+
+		missingVolumePolicy: *args.missingVolumePolicy,
+		takeoverPolicy:      takeoverPolicy,
+		leaseDuration:       leaseDuration,
+		usage:               usage,
 	}
 
+	driver.recoverJournal()
+
 	ierr := driver.initVolumesFromMetadata()
 	if ierr != nil {
+		cancel()
 		return nil, ierr
 	}
 
+	if leaseDuration > 0 {
+		go driver.renewLeases()
+	}
+	if usage.interval > 0 {
+		go driver.reportUsage()
+	}
+
 	return driver, nil
 }
 
-//Create is creating a new instance of a volume.
-func (d *Driver) Create(r volume.Request) volume.Response {
-	d.Lock()
-	defer d.Unlock()
+//Shutdown is cancelling requests being polled and waits till the volume
+//operations in progress return.
+func (d *Driver) Shutdown() {
+	d.cancel()
+	d.operations.Wait()
+}
+
+//lockVolume is locking a volume for an operation and returns the function
+//unlocking it once the operation is finished.
+func (d *Driver) lockVolume(name string) func() {
+	d.operations.Add(1)
+	unlock := d.locks.lock(name)
+	return func() {
+		unlock()
+		d.operations.Done()
+	}
+}
+
+//Create is creating a new instance of a volume. Steps done before a failure
+//are rolled back, except that adopted volumes are never deleted.
+func (d *Driver) Create(r volume.Request) (resp volume.Response) {
+	unlock := d.lockVolume(r.Name)
+	defer unlock()
 	log.Info("Creating a new volume")
 
+	//Repeated creates of an existing volume succeed
+	if state, ok := d.lookupVolume(r.Name); ok && !state.Missing {
+		err := d.checkCompatible(r, state.Size, state.Type, state.Filesystem)
+		if err != nil {
+			log.Error(err.Error())
+			return volume.Response{Err: err.Error()}
+		}
+		log.Infof("Volume %s already exists", r.Name)
+		return volume.Response{}
+	}
+
+	entry, err := d.beginJournal(journalCreate, r.Name)
+	if err != nil {
+		log.Error(err.Error())
+		return volume.Response{Err: err.Error()}
+	}
+
+	//An incomplete rollback is finished from the journal on the next start
+	undo := &rollback{name: r.Name}
+	defer func() {
+		if resp.Err != "" && undo.run() != nil {
+			return
+		}
+		entry.finish()
+	}()
+
 	isNewVolume := true
 	shouldDoFormatting := true
 	volumeID := ""
 	snapshotID := ""
 	diskSize := d.size
 	diskType := d.diskType
-	var err error
 
 	diskSizeParam := r.Options["volume_size"]
 	if len(diskSizeParam) > 0 {
@@ -123,12 +262,39 @@ func (d *Driver) Create(r volume.Request) volume.Response {
 		diskType = diskTypeParam
 	}
 
+	fsType := d.filesystem
+	fsTypeParam := r.Options[fsTypeOption]
+	if len(fsTypeParam) > 0 {
+		fsType = fsTypeParam
+	}
+	filesystem, err := d.utilities.Filesystem(fsType)
+	if err != nil {
+		log.Error(err.Error())
+		return volume.Response{Err: err.Error()}
+	}
+
+	autoGrowParam := r.Options[autoGrowOption]
+	if len(autoGrowParam) > 0 {
+		if _, err := strconv.ParseBool(autoGrowParam); err != nil {
+			return volume.Response{Err: fmt.Sprintf("Invalid %s option %q, use true or false", autoGrowOption, autoGrowParam)}
+		}
+	}
+
+	forceFormat := false
+	forceFormatParam := r.Options[forceFormatOption]
+	if len(forceFormatParam) > 0 {
+		forceFormat, err = strconv.ParseBool(forceFormatParam)
+		if err != nil {
+			return volume.Response{Err: fmt.Sprintf("Invalid %s option %q, use true or false", forceFormatOption, forceFormatParam)}
+		}
+	}
+
 	vol := profitbricks.Volume{
 		Properties: profitbricks.VolumeProperties{
 			Size:        diskSize,
 			Type:        diskType,
 			LicenceType: "OTHER",
-			Name:        fmt.Sprintf("%s:%s", r.Name, etag),
+			Name:        taggedVolumeName(r.Name, nil),
 		},
 	}
 
@@ -158,6 +324,16 @@ func (d *Driver) Create(r volume.Request) volume.Response {
 		return volume.Response{Err: err.Error()}
 	}
 
+	//The options of existing filesystems are resolved once the filesystem is found
+	var fsOptions filesystemOptions
+	if shouldDoFormatting {
+		fsOptions, err = d.resolveFilesystemOptions(r.Options, filesystem, true)
+		if err != nil {
+			log.Error(err.Error())
+			return volume.Response{Err: err.Error()}
+		}
+	}
+
 	if isNewVolume {
 		//Check volume name is unique in the datacenter
 		volumesresp, err := d.client.ListVolumes(d.datacenterID)
@@ -169,14 +345,34 @@ func (d *Driver) Create(r volume.Request) volume.Response {
 		log.Info(volumesresp)
 
 		for _, v := range volumesresp.Items {
-			if v.Properties.Name == vol.Properties.Name {
-				errorAlreadyExists := fmt.Sprintf("failed to create volume '%s', volume with this name already exists in datacenter '%s'", r.Name, d.datacenterID)
-				log.Errorf(errorAlreadyExists)
-				return volume.Response{Err: errorAlreadyExists}
+			if name, _ := managedVolumeName(v); name == r.Name {
+				//The volume was created and formatted by the plugin, possibly on another host
+				//The filesystem is not known till the volume is mounted
+				err = d.checkCompatible(r, v.Properties.Size, v.Properties.Type, "")
+				if err != nil {
+					log.Error(err.Error())
+					return volume.Response{Err: err.Error()}
+				}
+				log.Infof("Volume %s already exists in datacenter '%s', registering it", r.Name, d.datacenterID)
+				entry.VolumeID = v.ID
+				err = d.registerVolume(r, v.ID, v.Properties.Size, v.Properties.Type, filesystem.Name(), fsOptions, "", undo, entry)
+				if err != nil {
+					return volume.Response{Err: err.Error()}
+				}
+				return volume.Response{}
 			}
 		}
 
+		//Volumes restored from a snapshot take longer to provision
+		operation := operationCreate
+		if vol.Properties.Image != "" {
+			operation = operationSnapshot
+		}
+
 		//Creates a volume
+		entry.Created = true
+		entry.VolumeName = vol.Properties.Name
+		entry.step(journalStepCreate)
 		createresp, err := d.client.CreateVolume(d.datacenterID, vol)
 		log.Info(createresp)
 		if err != nil {
@@ -186,8 +382,18 @@ func (d *Driver) Create(r volume.Request) volume.Response {
 
 		volumeID = createresp.ID
 		log.Info("Volume provisioned:", vol.Properties.Name)
+		createdID := volumeID
+		undo.add("deleting the created volume "+createdID, func() error {
+			return d.deleteVolume(createdID)
+		})
+		entry.VolumeID = volumeID
+		entry.waiting(createresp.Headers.Get("Location"))
 
-		err = d.waitTillProvisioned(createresp.Headers.Get("Location"))
+		if createresp.Headers.Get("Location") == "" {
+			err = d.waitTillAvailable(operation, volumeID)
+		} else {
+			err = d.waitTillProvisioned(operation, createresp.Headers.Get("Location"))
+		}
 
 		if err != nil {
 			log.Error(err.Error())
@@ -196,14 +402,20 @@ func (d *Driver) Create(r volume.Request) volume.Response {
 	}
 
 	//Attach volume
+	entry.VolumeID = volumeID
+	entry.step(journalStepAttach)
 	attachResp, err := d.client.AttachVolume(d.datacenterID, d.serverID, volumeID)
 	if err != nil {
 		log.Errorf("Arguments: %s %s %s", d.datacenterID, d.serverID, vol.ID)
 		log.Errorf("failed to attach a volume '%v', error msg: %q", r.Name, attachResp.Response)
 		return volume.Response{Err: err.Error()}
 	}
+	undo.add("detaching the volume "+volumeID, func() error {
+		return d.detachVolume(volumeID)
+	})
+	entry.waiting(attachResp.Headers.Get("Location"))
 
-	err = d.waitTillProvisioned(attachResp.Headers.Get("Location"))
+	err = d.waitTillProvisioned(operationAttach, attachResp.Headers.Get("Location"))
 	log.Info("Volume attached:", attachResp.Properties.Name)
 
 	if err != nil {
@@ -211,95 +423,210 @@ func (d *Driver) Create(r volume.Request) volume.Response {
 		return volume.Response{Err: err.Error()}
 	}
 
+	//Existing volumes keep their own size and type
+	if attachResp.Properties.Size > 0 {
+		diskSize = attachResp.Properties.Size
+		diskType = attachResp.Properties.Type
+	}
+
 	//Sets a metadata
-	log.Info("Getting device name from : ", d.metadataPath)
-	volumeName, foundDevice, err := d.utilities.GetDeviceName()
+	entry.step(journalStepFormat)
+	volumeName, err := d.resolveDevice(r.Name, volumeID)
 	if err != nil {
 		log.Error(err.Error())
 		return volume.Response{Err: err.Error()}
 	}
 
-	if foundDevice {
-		//Sets a partition
-		if shouldDoFormatting {
-			log.Info("Starting formatting: VolumeName: ", volumeName, " VolumeId: ", volumeID)
-			err = d.utilities.FormatVolume(volumeName, volumeID)
-			if err != nil {
-				log.Error(err.Error())
-				return volume.Response{Err: err.Error()}
-			}
-		} else {
-			log.Info("Adjusting volume: VolumeName: ", volumeName, " VolumeId: ", volumeID)
-			err = d.utilities.TuneVolume(volumeName, volumeID)
-			if err != nil {
-				log.Error(err.Error())
-				return volume.Response{Err: err.Error()}
-			}
+	//Sets a partition
+	if shouldDoFormatting {
+		log.Info("Starting formatting: VolumeName: ", volumeName, " VolumeId: ", volumeID, " Filesystem: ", filesystem.Name())
+		err = d.checkBlankDevice(r.Name, volumeName, forceFormat)
+		if err == nil {
+			err = filesystem.Format(volumeName, volumeID, r.Name, fsOptions.mkfs)
+		}
+		if err != nil {
+			log.Error(err.Error())
+			return volume.Response{Err: err.Error()}
+		}
+	} else {
+		log.Info("Adjusting volume: VolumeName: ", volumeName, " VolumeId: ", volumeID)
+		filesystem, err = d.existingFilesystem(r, volumeName)
+		if err == nil {
+			//The mount options have to suit the filesystem found
+			fsOptions, err = d.resolveFilesystemOptions(r.Options, filesystem, false)
+		}
+		if err == nil {
+			err = filesystem.Check(volumeName)
+		}
+		if err == nil {
+			err = filesystem.SetUUID(volumeName, volumeID)
+		}
+		if err != nil {
+			log.Error(err.Error())
+			return volume.Response{Err: err.Error()}
 		}
 	}
 
-	volumePath := filepath.Join(d.mountPath, volumeID)
-	log.Info("Make directory for VolumePath: ", volumePath)
-	err = os.MkdirAll(volumePath, mountDirMode)
+	err = d.registerVolume(r, volumeID, diskSize, diskType, filesystem.Name(), fsOptions, volumeName, undo, entry)
 	if err != nil {
-		log.Error(err.Error())
 		return volume.Response{Err: err.Error()}
 	}
 
-	metadataFilePath := filepath.Join(d.metadataPath, r.Name)
-
-	log.Infof("Metadata file path %s", metadataFilePath)
-
-	metadataFile, err := os.Create(metadataFilePath)
+	entry.step(journalStepDetach)
+	detachResp, err := d.client.DetachVolume(d.datacenterID, d.serverID, volumeID)
 	if err != nil {
-		log.Errorf("failed to create metadata file '%v' for volume '%v'", metadataFilePath, r.Name)
+		log.Errorf("failed to detach volume '%v' on server '%v'", volumeID, d.serverID)
 		return volume.Response{Err: err.Error()}
 	}
+	entry.waiting(detachResp.Get("Location"))
 
-	err = metadataFile.Chmod(metadataFileMode)
+	err = d.waitTillProvisioned(operationDetach, detachResp.Get("Location"))
 	if err != nil {
-		os.Remove(metadataFilePath)
-		log.Errorf("failed to change the mode for the metadata file '%v' for volume '%v'", metadataFilePath, r.Name)
 		return volume.Response{Err: err.Error()}
 	}
 
-	d.volumes[r.Name] = &volumeState{
-		VolumeID:   volumeID,
-		MountPoint: volumePath,
-		DeviceName: volumeName,
+	return volume.Response{}
+}
+
+//existingFilesystem is returning the filesystem found on the device of an
+//existing volume or snapshot, which has to match the requested one if any.
+func (d *Driver) existingFilesystem(r volume.Request, devicePath string) (Filesystem, error) {
+	fsType, err := d.utilities.DetectFilesystem(devicePath)
+	if err != nil {
+		return nil, err
+	}
+	if fsType == "" {
+		return nil, fmt.Errorf("Volume %q has no filesystem on %s", r.Name, devicePath)
 	}
 
-	jsn, _ := json.MarshalIndent(d.volumes, "", "\t")
-	log.Info("Volumes: ", string(jsn))
+	requested := r.Options[fsTypeOption]
+	if len(requested) > 0 && requested != fsType {
+		return nil, fmt.Errorf("Volume %q has filesystem %s, requested filesystem is %s", r.Name, fsType, requested)
+	}
+	return d.utilities.Filesystem(fsType)
+}
 
-	jsn, err = json.Marshal(d.volumes[r.Name])
+//registerVolume is creating the mount directory and the metadata of a volume.
+func (d *Driver) registerVolume(r volume.Request, volumeID string, size int, diskType string, filesystem string, fsOptions filesystemOptions, deviceName string, undo *rollback, entry *journalEntry) error {
+	volumePath := filepath.Join(d.mountPath, volumeID)
+	log.Info("Make directory for VolumePath: ", volumePath)
+	_, statErr := os.Stat(volumePath)
+	err := os.MkdirAll(volumePath, mountDirMode)
 	if err != nil {
-		return volume.Response{Err: err.Error()}
+		log.Error(err.Error())
+		return err
+	}
+	if os.IsNotExist(statErr) {
+		undo.add("removing the mount directory "+volumePath, func() error {
+			return os.Remove(volumePath)
+		})
 	}
 
-	detachResp, err := d.client.DetachVolume(d.datacenterID, d.serverID, volumeID)
-	if err != nil {
-		log.Errorf("failed to detach volume '%v' on server '%v'", volumeID, d.serverID)
-		return volume.Response{Err: err.Error()}
+	options := make(map[string]string)
+	for k, v := range r.Options {
+		options[k] = v
 	}
 
-	err = d.waitTillProvisioned(detachResp.Get("Location"))
+	state := &volumeState{
+		Version:      metadataVersion,
+		VolumeID:     volumeID,
+		DatacenterID: d.datacenterID,
+		Size:         size,
+		Type:         diskType,
+		Filesystem:   filesystem,
+		MkfsOptions:  fsOptions.mkfs,
+		MountOptions: fsOptions.mount,
+		Options:      options,
+		CreatedAt:    time.Now().UTC(),
+		MountPoint:   volumePath,
+		DeviceName:   deviceName,
+		Mounts:       make(map[string]bool),
+	}
+
+	jsn, _ := json.MarshalIndent(state, "", "\t")
+	log.Info("Volume: ", string(jsn))
+
+	entry.step(journalStepMetadata)
+	err = d.writeMetadata(r.Name, state)
 	if err != nil {
-		return volume.Response{Err: err.Error()}
+		log.Errorf("failed to write metadata file for volume '%v': %v", r.Name, err)
+		return err
 	}
+	d.storeVolume(r.Name, state)
+	undo.add("removing the metadata", func() error {
+		d.forgetVolume(r.Name)
+		return os.Remove(filepath.Join(d.metadataPath, r.Name))
+	})
+	return nil
+}
 
-	return volume.Response{}
+//checkCompatible is checking the size, type and filesystem requested for a
+//volume match the ones of an existing volume. An unknown filesystem is empty.
+func (d *Driver) checkCompatible(r volume.Request, size int, diskType string, filesystem string) error {
+	requestedSize := r.Options["volume_size"]
+	if len(requestedSize) > 0 {
+		diskSize, err := strconv.Atoi(requestedSize)
+		if err != nil {
+			return err
+		}
+		if diskSize != size {
+			return fmt.Errorf("Volume %q already exists with size %d GB, requested size is %d GB", r.Name, size, diskSize)
+		}
+	}
+
+	requestedType := r.Options["volume_type"]
+	if len(requestedType) > 0 && requestedType != diskType {
+		return fmt.Errorf("Volume %q already exists with type %s, requested type is %s", r.Name, diskType, requestedType)
+	}
+
+	requestedFilesystem := r.Options[fsTypeOption]
+	if len(requestedFilesystem) > 0 && len(filesystem) > 0 && requestedFilesystem != filesystem {
+		return fmt.Errorf("Volume %q already exists with filesystem %s, requested filesystem is %s", r.Name, filesystem, requestedFilesystem)
+	}
+	return nil
 }
 
 //Mount is attaching and mounting a volume.
 func (d *Driver) Mount(r volume.MountRequest) volume.Response {
-	d.Lock()
-	defer d.Unlock()
+	unlock := d.lockVolume(r.Name)
+	defer unlock()
 	log.Infof("Mounting Volume: %s", r.Name)
 
-	vol := d.volumes[r.Name]
+	vol, err := d.lookupOrAdoptVolume(r.Name)
+	if err != nil {
+		return volume.Response{Err: err.Error()}
+	}
+	if vol.Missing {
+		return volume.Response{Err: fmt.Sprintf("Volume %q no longer exists in datacenter '%s'", r.Name, d.datacenterID)}
+	}
 	log.Info(vol.DeviceName)
 
+	//The volume is already attached and mounted for another caller
+	if len(vol.Mounts) > 0 {
+		log.Infof("Volume %s is already mounted, adding reference %s", r.Name, r.ID)
+		d.updateVolume(func() { vol.Mounts[r.ID] = true })
+		d.saveMounts(r.Name)
+		return volume.Response{Mountpoint: vol.MountPoint}
+	}
+
+	err = d.acquireLease(r.Name, vol.VolumeID)
+	if err != nil {
+		log.Error(err.Error())
+		return volume.Response{Err: err.Error()}
+	}
+	defer func() {
+		//Release the lease if attaching or mounting failed
+		if len(vol.Mounts) == 0 {
+			d.releaseLease(r.Name, vol.VolumeID)
+		}
+	}()
+
+	err = d.takeOver(r.Name, vol.VolumeID)
+	if err != nil {
+		log.Error(err.Error())
+		return volume.Response{Err: err.Error()}
+	}
+
 	attachResp, err := d.client.AttachVolume(d.datacenterID, d.serverID, vol.VolumeID)
 	if err != nil {
 		log.Errorf("Arguments: %s %s %s", d.datacenterID, d.serverID, vol.VolumeID)
@@ -307,39 +634,80 @@ func (d *Driver) Mount(r volume.MountRequest) volume.Response {
 		return volume.Response{Err: err.Error()}
 	}
 
-	err = d.waitTillProvisioned(attachResp.Headers.Get("Location"))
+	err = d.waitTillProvisioned(operationAttach, attachResp.Headers.Get("Location"))
+	if err != nil {
+		log.Error(err.Error())
+		return volume.Response{Err: err.Error()}
+	}
+	d.invalidateRemoteVolumes()
 	log.Info("Volume attached:", attachResp.Properties.Name)
 
-	volumePath := filepath.Join("/dev", "disk", "by-uuid", vol.VolumeID)
-	err = d.utilities.MountVolume(volumePath, vol.MountPoint)
+	volumePath, err := d.volumeDevice(r.Name, vol.VolumeID)
+	if err != nil {
+		log.Error(err.Error())
+		return volume.Response{Err: err.Error()}
+	}
+	d.updateVolume(func() { vol.DeviceName = volumePath })
+
+	err = d.utilities.MountVolume(volumePath, vol.MountPoint, vol.MountOptions)
 	if err != nil {
 		log.Error(err.Error())
 		return volume.Response{Err: err.Error()}
 	}
 
+	//Volumes registered from the datacenter record the default filesystem
+	filesystem, err := d.utilities.DetectFilesystem(volumePath)
+	if err != nil {
+		log.Errorf("failed to detect the filesystem of volume '%v': %v", r.Name, err)
+	} else if filesystem != "" && filesystem != vol.Filesystem {
+		log.Infof("Volume %s has filesystem %s, recorded was %s", r.Name, filesystem, vol.Filesystem)
+		d.updateVolume(func() { vol.Filesystem = filesystem })
+	}
+
+	d.updateVolume(func() { vol.Mounts = map[string]bool{r.ID: true} })
+	d.saveMounts(r.Name)
+
+	d.autoGrow(r.Name, vol)
+
 	return volume.Response{
 		Mountpoint: vol.MountPoint,
 	}
 }
 
-//getVolumeDevicePath returning device path with uuid.
-func (d *Driver) getVolumeDevicePath(volumeID string) string {
-	return filepath.Join("/dev", "disk", "by-uuid", volumeID)
-}
-
 //Unmount is detacing and unmounting a volume.
 func (d *Driver) Unmount(r volume.UnmountRequest) volume.Response {
-	d.Lock()
-	defer d.Unlock()
+	unlock := d.lockVolume(r.Name)
+	defer unlock()
 	log.Info("Unmounting Volume")
 
-	vol := d.volumes[r.Name]
-	volumePath := d.getVolumeDevicePath(vol.VolumeID)
-	err := d.utilities.UnmountVolume(volumePath)
+	vol, ok := d.lookupVolume(r.Name)
+	if !ok {
+		return volume.Response{Err: fmt.Sprintf("Volume %q does not exist", r.Name)}
+	}
+
+	hadReference := vol.Mounts[r.ID]
+	if !hadReference {
+		log.Warnf("Volume %s has no reference %s", r.Name, r.ID)
+	}
+	d.updateVolume(func() { delete(vol.Mounts, r.ID) })
+
+	//Other callers are still using the volume
+	if len(vol.Mounts) > 0 {
+		log.Infof("Volume %s is still mounted for %d other references", r.Name, len(vol.Mounts))
+		d.saveMounts(r.Name)
+		return volume.Response{}
+	}
+
+	err := d.utilities.UnmountVolume(vol.MountPoint)
 	if err != nil {
-		log.Error("Error occured while unmounting volume", err.Error())
+		if hadReference {
+			d.updateVolume(func() { vol.Mounts[r.ID] = true })
+		}
+		log.Error("Error occured while unmounting volume ", err.Error())
 		return volume.Response{Err: err.Error()}
 	}
+	d.updateVolume(func() { vol.DeviceName = "" })
+	d.saveMounts(r.Name)
 
 	detachResp, err := d.client.DetachVolume(d.datacenterID, d.serverID, vol.VolumeID)
 	if err != nil {
@@ -347,25 +715,57 @@ func (d *Driver) Unmount(r volume.UnmountRequest) volume.Response {
 		return volume.Response{Err: err.Error()}
 	}
 
-	err = d.waitTillProvisioned(detachResp.Get("Location"))
+	err = d.waitTillProvisioned(operationDetach, detachResp.Get("Location"))
 	if err != nil {
 		return volume.Response{Err: err.Error()}
 	}
+	d.invalidateRemoteVolumes()
+	d.releaseLease(r.Name, vol.VolumeID)
 
 	return volume.Response{}
 }
 
-//List is showing all volumes related for the driver.
+//List is showing all volumes related for the driver, including the ones
+//created by the plugin on other hosts.
 func (d *Driver) List(r volume.Request) volume.Response {
-	d.Lock()
-	defer d.Unlock()
 	volumes := []*volume.Volume{}
 	log.Info("Getting a Volume")
 
+	remote := d.remoteVolumes()
+	attached, err := d.cachedAttachedServers()
+	if err != nil {
+		log.Error(err.Error())
+	}
+
+	//The states are copied as volumes may be mounted or unmounted meanwhile
+	d.RLock()
+	states := make(map[string]*volumeState, len(d.volumes))
 	for name, state := range d.volumes {
-		volumes = append(volumes, &volume.Volume{
+		states[name] = state.copy()
+	}
+	d.RUnlock()
+
+	for name, state := range states {
+		vol := &volume.Volume{
 			Name:       name,
 			Mountpoint: state.MountPoint,
+		}
+		if v, ok := remote[name]; ok {
+			vol.Status = d.volumeStatus(state, &v, attached)
+		} else {
+			vol.Status = d.volumeStatus(state, nil, attached)
+		}
+		volumes = append(volumes, vol)
+	}
+
+	for name, v := range remote {
+		if _, ok := d.lookupVolume(name); ok {
+			continue
+		}
+		volumes = append(volumes, &volume.Volume{
+			Name:       name,
+			Mountpoint: filepath.Join(d.mountPath, v.ID),
+			Status:     d.volumeStatus(nil, &v, attached),
 		})
 	}
 	return volume.Response{Volumes: volumes}
@@ -375,12 +775,34 @@ func (d *Driver) List(r volume.Request) volume.Response {
 func (d *Driver) Get(r volume.Request) volume.Response {
 	log.Info("Getting a Volume")
 
-	if d.volumes[r.Name] == nil {
+	state, ok := d.copyVolume(r.Name)
+	remote, remoteOK := d.remoteVolumes()[r.Name]
+	if !ok && !remoteOK {
 		return volume.Response{}
 	}
+
+	attached, err := d.cachedAttachedServers()
+	if err != nil {
+		log.Error(err.Error())
+	}
+
+	//Volumes created on other hosts are registered when used
+	if !ok {
+		return volume.Response{Volume: &volume.Volume{
+			Name:       r.Name,
+			Mountpoint: filepath.Join(d.mountPath, remote.ID),
+			Status:     d.volumeStatus(nil, &remote, attached),
+		}}
+	}
+
 	vol := &volume.Volume{
-		Name:       d.volumes[r.Name].DeviceName,
-		Mountpoint: d.volumes[r.Name].MountPoint,
+		Name:       r.Name,
+		Mountpoint: state.MountPoint,
+	}
+	if remoteOK {
+		vol.Status = d.volumeStatus(state, &remote, attached)
+	} else {
+		vol.Status = d.volumeStatus(state, nil, attached)
 	}
 
 	return volume.Response{Volume: vol}
@@ -388,28 +810,62 @@ func (d *Driver) Get(r volume.Request) volume.Response {
 
 //Remove is removing volume from a server and the Profitbricks data center.
 func (d *Driver) Remove(r volume.Request) volume.Response {
-	d.Lock()
-	defer d.Unlock()
-	log.Info("Iterating throug map")
+	unlock := d.lockVolume(r.Name)
+	defer unlock()
+	log.Infof("Removing Volume: %s", r.Name)
+
+	key := r.Name
+	vol, err := d.lookupOrAdoptVolume(key)
+	if err != nil {
+		return volume.Response{Err: err.Error()}
+	}
 
-	vol := &volumeState{}
-	var key string
-	for k, v := range d.volumes {
-		log.Infof("Key %s", k)
-		log.Infof("v.MountPoint == r.Name ", v.MountPoint == r.Name)
-		if v.DeviceName == r.Name {
-			key = k
-			vol = v
-			break
+	if len(vol.Mounts) > 0 {
+		return volume.Response{Err: fmt.Sprintf("Volume %q is in use by %d containers", r.Name, len(vol.Mounts))}
+	}
+
+	if vol.Missing {
+		log.Warnf("Volume %s no longer exists in datacenter '%s', removing the local state only", r.Name, d.datacenterID)
+		err := d.removeLocalState(key, vol)
+		if err != nil {
+			return volume.Response{Err: err.Error()}
 		}
+		return volume.Response{}
+	}
+
+	if d.leaseDuration > 0 {
+		lease, err := d.currentLease(vol.VolumeID)
+		if err == nil && lease.valid() && lease.ServerID != d.serverID {
+			return volume.Response{Err: fmt.Sprintf("Volume %q is leased to server '%v'", r.Name, lease.ServerID)}
+		}
+	}
+
+	//Volumes in use on other servers are never deleted
+	owner, err := d.findAttachedServer(vol.VolumeID)
+	if err != nil {
+		log.Error(err.Error())
+		return volume.Response{Err: err.Error()}
+	}
+	if owner != "" && owner != d.serverID {
+		return volume.Response{Err: fmt.Sprintf("Volume %q is attached to server '%v', refusing to remove it", r.Name, owner)}
+	}
+
+	entry, err := d.beginJournal(journalRemove, key)
+	if err != nil {
+		log.Error(err.Error())
+		return volume.Response{Err: err.Error()}
 	}
+	//Failures are reported to Docker, only crashes are recovered from the journal
+	defer entry.finish()
+	entry.VolumeID = vol.VolumeID
 
 	alreadyRemoved := false
 	//Try to detach the volume, so it could be deleted.
+	entry.step(journalStepDetach)
 	resp, err := d.client.DetachVolume(d.datacenterID, d.serverID, vol.VolumeID)
 	if err != nil {
-		if apiError, ok := err.(profitbricks.ApiError); ok {
-			if apiError.HttpStatusCode() == 404 {
+		if statusCode, ok := apiStatusCode(err); ok {
+			if statusCode == 404 {
 				alreadyRemoved = true
 			} else {
 				log.Errorf("failed to detach volume '%v' on server '%v'", vol.VolumeID, d.serverID)
@@ -421,54 +877,63 @@ func (d *Driver) Remove(r volume.Request) volume.Response {
 	}
 
 	if !alreadyRemoved {
-		err := d.waitTillProvisioned(resp.Get("Location"))
+		entry.waiting(resp.Get("Location"))
+		err := d.waitTillProvisioned(operationDetach, resp.Get("Location"))
 		if err != nil {
 			return volume.Response{Err: err.Error()}
 		}
 	}
 
+	entry.step(journalStepDelete)
 	resp, err = d.client.DeleteVolume(d.datacenterID, vol.VolumeID)
 	if err != nil {
 		log.Errorf("failed to delete volume '%s' from data center '%s'", vol.VolumeID, d.datacenterID)
 		return volume.Response{Err: err.Error()}
 	}
+	entry.waiting(resp.Get("Location"))
 
-	err = d.waitTillProvisioned(resp.Get("Location"))
+	err = d.waitTillProvisioned(operationDelete, resp.Get("Location"))
 	if err != nil {
 		return volume.Response{Err: err.Error()}
 	}
+	d.invalidateRemoteVolumes()
 
-	//Remove mount folder
-	err = os.Remove(vol.MountPoint)
+	err = d.removeLocalState(key, vol)
 	if err != nil {
-		log.Error(err.Error())
 		return volume.Response{Err: err.Error()}
 	}
 
-	metadataFilePath := filepath.Join(d.metadataPath, key)
-	err = os.Remove(metadataFilePath)
-	if err != nil {
+	return volume.Response{}
+}
+
+//removeLocalState is removing the mount folder and the metadata of a volume.
+func (d *Driver) removeLocalState(name string, vol *volumeState) error {
+	//Remove mount folder
+	err := os.Remove(vol.MountPoint)
+	if err != nil && !os.IsNotExist(err) {
 		log.Error(err.Error())
-		return volume.Response{Err: err.Error()}
+		return err
 	}
 
-	delete(d.volumes, key)
-
+	metadataFilePath := filepath.Join(d.metadataPath, name)
+	err = os.Remove(metadataFilePath)
 	if err != nil {
-		return volume.Response{Err: err.Error()}
+		log.Error(err.Error())
+		return err
 	}
 
-	return volume.Response{}
+	d.forgetVolume(name)
+	return nil
 }
 
 //Path is returing path info.
 func (d *Driver) Path(r volume.Request) volume.Response {
-	d.Lock()
-	defer d.Unlock()
-
-	if state, ok := d.volumes[r.Name]; ok {
+	if state, ok := d.lookupVolume(r.Name); ok {
 		return volume.Response{Mountpoint: state.MountPoint}
 	}
+	if v, ok := d.remoteVolumes()[r.Name]; ok {
+		return volume.Response{Mountpoint: filepath.Join(d.mountPath, v.ID)}
+	}
 
 	return volume.Response{Err: fmt.Sprintf("Volume %q does not exist", r.Name)}
 }
@@ -501,8 +966,8 @@ func (d *Driver) findVolumeByName(volumeName string) (string, error) {
 		//Check volume name is unique in the datacenter
 		volumesresp, err := d.client.ListVolumes(d.datacenterID)
 		if err != nil {
-			log.Errorf("failed to list volumes in dc '%v'", d.datacenterID)
-			return "", fmt.Errorf(volumesresp.Response)
+			log.Errorf("failed to list volumes in dc '%v': %v", d.datacenterID, err)
+			return "", err
 		}
 
 		for _, v := range volumesresp.Items {
@@ -512,18 +977,15 @@ func (d *Driver) findVolumeByName(volumeName string) (string, error) {
 				return volumeID, nil
 			}
 		}
-		//Try to discover volume with etag suffix
-		if !strings.HasSuffix(volumeName, etag) {
-			volumeSuffixName := fmt.Sprintf("%s:%s", volumeName, etag)
-			for _, v := range volumesresp.Items {
-				if v.Properties.Name == volumeSuffixName {
-					volumeID = v.ID
-					log.Infof("Found volume uuid %s with name %s", volumeID, volumeSuffixName)
-					return volumeID, nil
-				}
+		//Try to discover volume with etag suffix, possibly leased
+		for _, v := range volumesresp.Items {
+			if name, ok := managedVolumeName(v); ok && name == volumeName {
+				volumeID = v.ID
+				log.Infof("Found volume uuid %s with name %s", volumeID, v.Properties.Name)
+				return volumeID, nil
 			}
 		}
-		return "", fmt.Errorf("Volume with name %s could not be found", volumeName)
+		return "", missingVolumeError{name: volumeName}
 	}
 
 	return "", nil
@@ -545,10 +1007,10 @@ func (d *Driver) findVolumeByID(volumeID string, vol *profitbricks.Volume, isNew
 		}
 		log.Info(volResp)
 		//Adding docker suffix tag in case it is not added
-		if !(strings.HasSuffix(volResp.Properties.Name, etag)) {
+		if _, ok := managedVolumeName(*volResp); !ok {
 			log.Infof("Update name of the volume %s with the suffix %s", volResp.Properties.Name, etag)
 			volProps := profitbricks.VolumeProperties{
-				Name: fmt.Sprintf("%s:%s", r.Name, etag),
+				Name: taggedVolumeName(r.Name, nil),
 			}
 
 			volEditResp, err := d.client.UpdateVolume(d.datacenterID, volumeID, volProps)
@@ -577,7 +1039,7 @@ func (d *Driver) findSnapshotByName(r volume.Request) (string, error) {
 		snapshotsresp, err := d.client.ListSnapshots()
 		if err != nil {
 			log.Errorf("failed to create a volume '%v'", r.Name)
-			return "", fmt.Errorf(err.Error())
+			return "", err
 		}
 		log.Info(snapshotsresp)
 
@@ -591,12 +1053,12 @@ func (d *Driver) findSnapshotByName(r volume.Request) (string, error) {
 		}
 	}
 
-	return "", nil
+	return snapshotID, nil
 }
 
 //findSnapshotByID is trying to discover a snapshot by snapshotId.
 func (d *Driver) findSnapshotByID(snapshotID string, volumeID string, vol *profitbricks.Volume, isNewVolume bool, shouldDoFormatting bool, r volume.Request) (string, bool, bool, error) {
-	if !d.utilities.IsUUID(volumeID) && d.utilities.IsUUID(snapshotID) {
+	if !d.utilities.IsUUID(volumeID) && !d.utilities.IsUUID(snapshotID) {
 		snapshotID = r.Options["snapshot_id"]
 	}
 	if !d.utilities.IsUUID(volumeID) && d.utilities.IsUUID(snapshotID) {
@@ -623,66 +1085,222 @@ func (d *Driver) initVolumesFromMetadata() error {
 		return ferr
 	}
 
+	d.removeStaleTmpFiles()
+	existingVolumes := d.listVolumeIDs()
+
 	for _, metadataFile := range metadataFiles {
+		if !isMetadataFile(metadataFile) {
+			continue
+		}
 		volumeName := metadataFile.Name()
 		metadataFilePath := filepath.Join(d.metadataPath, volumeName)
 
 		log.Infof("Initializing volume '%v' from metadata file '%v'", volumeName, metadataFilePath)
 
 		volumeState, ierr := d.initVolume(volumeName)
+		if _, ok := ierr.(missingVolumeError); ok {
+			d.handleMissingVolume(volumeName, volumeState, ierr)
+			continue
+		}
 		if ierr != nil {
-			return ierr
+			//Do not block the other volumes, the volume is retried on the next start
+			log.Errorf("Skipping volume '%v': %v", volumeName, ierr)
+			continue
+		}
+
+		if existingVolumes != nil && !existingVolumes[volumeState.VolumeID] {
+			d.handleMissingVolume(volumeName, volumeState, missingVolumeError{name: volumeName, volumeID: volumeState.VolumeID})
+			continue
 		}
 
 		d.volumes[volumeName] = volumeState
 	}
 
+	return d.restoreMounts()
+}
+
+//restoreMounts re-adopts volumes still mounted and in use after a restart and
+//cleans up stale mounts, mount references and attachments.
+func (d *Driver) restoreMounts() error {
+	mountPoints, err := d.utilities.GetMountPoints()
+	if err != nil {
+		return err
+	}
+
+	attached := make(map[string]bool)
+	attachedVolumes, err := d.client.ListAttachedVolumes(d.datacenterID, d.serverID)
+	if err != nil {
+		//Without the attachments nothing is detached, mounts are still restored
+		log.Errorf("failed to list volumes attached to server '%v': %v", d.serverID, err)
+		attached = nil
+	} else {
+		for _, v := range attachedVolumes.Items {
+			attached[v.ID] = true
+		}
+	}
+
+	for name, vol := range d.volumes {
+		_, isMounted := mountPoints[vol.MountPoint]
+		isUsed := len(vol.Mounts) > 0
+
+		if isMounted && isUsed {
+			log.Infof("Volume '%v' is still mounted at '%v' for %d references, adopting it", name, vol.MountPoint, len(vol.Mounts))
+			vol.DeviceName = mountPoints[vol.MountPoint]
+			continue
+		}
+
+		if isMounted {
+			log.Infof("Volume '%v' is mounted at '%v' without references, unmounting it", name, vol.MountPoint)
+			err = d.utilities.UnmountVolume(vol.MountPoint)
+			if err != nil {
+				log.Errorf("failed to unmount stale mount of volume '%v': %v", name, err)
+				continue
+			}
+		}
+
+		if isUsed {
+			log.Infof("Volume '%v' is not mounted anymore, dropping %d stale references", name, len(vol.Mounts))
+			vol.Mounts = make(map[string]bool)
+			d.saveMounts(name)
+		}
+
+		if attached[vol.VolumeID] {
+			log.Infof("Volume '%v' is attached without being mounted, detaching it", name)
+			err = d.detachVolume(vol.VolumeID)
+			if err != nil {
+				log.Errorf("failed to detach stale attachment of volume '%v': %v", name, err)
+			}
+		}
+	}
+
 	return nil
 }
 
-//initVolume init volume from the API.
-func (d *Driver) initVolume(name string) (*volumeState, error) {
-	volumeID, _ := d.findVolumeByName(name)
-	if volumeID == "" {
-		log.Errorf("Volume '%v' not found", name)
-		return nil, fmt.Errorf("Volume '%v' not found", name)
+//listVolumeIDs is returning IDs of volumes in the datacenter, or nil if they
+//could not be listed.
+func (d *Driver) listVolumeIDs() map[string]bool {
+	volumesresp, err := d.client.ListVolumes(d.datacenterID)
+	if err != nil {
+		log.Errorf("failed to list volumes in dc '%v', missing volumes are not detected: %v", d.datacenterID, err)
+		return nil
 	}
 
-	volumePath := filepath.Join(d.mountPath, volumeID)
+	volumeIDs := make(map[string]bool)
+	for _, v := range volumesresp.Items {
+		volumeIDs[v.ID] = true
+	}
+	return volumeIDs
+}
 
-	merr := os.MkdirAll(volumePath, mountDirMode)
-	if merr != nil {
-		log.Errorf("failed to create the volume mount path '%v'", volumePath)
-		return nil, fmt.Errorf("failed to create the volume mount path '%v'", volumePath)
+//deleteVolume is deleting a volume from the datacenter and waits till it is done.
+func (d *Driver) deleteVolume(volumeID string) error {
+	deleteResp, err := d.client.DeleteVolume(d.datacenterID, volumeID)
+	if err != nil {
+		log.Errorf("failed to delete volume '%s' from data center '%s'", volumeID, d.datacenterID)
+		return err
 	}
 
-	d.utilities.UnmountVolume(d.getVolumeDevicePath(volumeID))
+	return d.waitTillProvisioned(operationDelete, deleteResp.Get("Location"))
+}
+
+//detachVolume is detaching a volume from the server and waits till it is done.
+func (d *Driver) detachVolume(volumeID string) error {
+	return d.detachVolumeFrom(d.serverID, volumeID)
+}
 
-	volumeState := &volumeState{
-		VolumeID:   volumeID,
-		MountPoint: volumePath,
+//detachVolumeFrom is detaching a volume from a server and waits till it is done.
+func (d *Driver) detachVolumeFrom(serverID string, volumeID string) error {
+	detachResp, err := d.client.DetachVolume(d.datacenterID, serverID, volumeID)
+	if err != nil {
+		log.Errorf("failed to detach volume '%v' on server '%v'", volumeID, serverID)
+		return err
 	}
 
-	return volumeState, nil
+	err = d.waitTillProvisioned(operationDetach, detachResp.Get("Location"))
+	if err != nil {
+		return err
+	}
+	d.invalidateRemoteVolumes()
+	return nil
 }
 
-//waitTillProvisioned is wating till a Profitbricks long executing request is done.
-func (d *Driver) waitTillProvisioned(path string) error {
-	for {
-		request, err := d.client.GetRequestStatus(path)
+//initVolume init volume from its metadata file.
+func (d *Driver) initVolume(name string) (*volumeState, error) {
+	volumeState, err := d.readMetadata(name)
+	if err != nil {
+		log.Errorf("failed to read metadata of volume '%v': %v", name, err)
+		return nil, err
+	}
+
+	if volumeState.Version < metadataVersion {
+		err = d.migrateMetadata(name, volumeState)
 		if err != nil {
-			return fmt.Errorf("failed to get request status for %s", path)
+			return volumeState, err
 		}
-		log.Debugf("Request status: %s", request.Metadata.Status)
-		log.Debugf("Request status path: %s", path)
+	}
 
-		if request.Metadata.Status == "DONE" {
-			return nil
-		}
-		if request.Metadata.Status == "FAILED" {
+	if volumeState.DatacenterID != d.datacenterID {
+		log.Warnf("Volume '%v' was created in datacenter '%v', the plugin uses '%v'", name, volumeState.DatacenterID, d.datacenterID)
+	}
 
-			return fmt.Errorf("Request failed with following error: %s", request.Metadata.Message)
-		}
-		time.Sleep(10 * time.Second)
+	merr := os.MkdirAll(volumeState.MountPoint, mountDirMode)
+	if merr != nil {
+		log.Errorf("failed to create the volume mount path '%v'", volumeState.MountPoint)
+		return nil, fmt.Errorf("failed to create the volume mount path '%v'", volumeState.MountPoint)
+	}
+
+	return volumeState, nil
+}
+
+//lookupVolume is returning the state of a volume.
+func (d *Driver) lookupVolume(name string) (*volumeState, bool) {
+	d.RLock()
+	defer d.RUnlock()
+	vol, ok := d.volumes[name]
+	return vol, ok
+}
+
+//copyVolume is returning a copy of the state of a volume, which can be read
+//while the volume is mounted or unmounted.
+func (d *Driver) copyVolume(name string) (*volumeState, bool) {
+	d.RLock()
+	defer d.RUnlock()
+	vol, ok := d.volumes[name]
+	if !ok {
+		return nil, false
+	}
+	return vol.copy(), true
+}
+
+//updateVolume is changing the state of a registered volume. Changes are made
+//under the driver lock in addition to the volume lock, so the state can be
+//copied without waiting for operations on the volume.
+func (d *Driver) updateVolume(update func()) {
+	d.Lock()
+	defer d.Unlock()
+	update()
+}
+
+//storeVolume is registering the state of a volume.
+func (d *Driver) storeVolume(name string, vol *volumeState) {
+	d.Lock()
+	defer d.Unlock()
+	d.volumes[name] = vol
+}
+
+//forgetVolume is unregistering a volume.
+func (d *Driver) forgetVolume(name string) {
+	d.Lock()
+	defer d.Unlock()
+	delete(d.volumes, name)
+}
+
+//saveMounts is persisting mount references. A failure is only logged as the
+//mount operation itself has already succeeded.
+func (d *Driver) saveMounts(name string) {
+	vol, _ := d.lookupVolume(name)
+	err := d.writeMetadata(name, vol)
+	if err != nil {
+		log.Errorf("failed to persist mount references of volume '%v': %v", name, err)
 	}
 }
@@ -0,0 +1,791 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/go-plugins-helpers/volume"
+	"github.com/profitbricks/profitbricks-sdk-go"
+)
+
+//Constances used by the driver tests.
+const (
+	testDatacenterID = "datacenter"
+	testServerID     = "server"
+)
+
+//testDriver is a driver running against the fake Cloud API and the fake host.
+type testDriver struct {
+	*Driver
+	cloud *fakeCloud
+	host  *fakeHost
+	dir   string
+}
+
+//newTestDriver is a constructor of a driver with fast polling, without leases
+//and usage reports. Attached volumes appear as /dev/vdb with 1 GB.
+func newTestDriver(t *testing.T) *testDriver {
+	return startTestDriver(t, testDir(t), newFakeCloud())
+}
+
+//testDir is creating the directory keeping metadata and mount points of a test driver.
+func testDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "docker-volume-profitbricks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+//startTestDriver is starting a test driver on the directory and the cloud,
+//which might hold state left by a previous driver.
+func startTestDriver(t *testing.T, dir string, cloud *fakeCloud) *testDriver {
+	return startLeasingDriver(t, dir, cloud, cloud, 0)
+}
+
+//startLeasingDriver is starting a test driver leasing mounted volumes, which
+//calls the cloud through the client.
+func startLeasingDriver(t *testing.T, dir string, cloud *fakeCloud, client CloudAPI, leaseDuration time.Duration) *testDriver {
+	return startConfiguredDriver(t, dir, cloud, client, newTestHost(), func(args *CommandLineArgs) {
+		args.leaseDuration = &leaseDuration
+	})
+}
+
+//newTestHost is returning a fake host without mounts, with the device of the
+//first attached volume.
+func newTestHost() *fakeHost {
+	host := newFakeHost()
+	host.setFile(mountInfoPath, "")
+	setDevice(host, "vdb", "virtio-pci-0000:00:06.0", 1)
+	host.setOutput("dumpe2fs", "Block count: 262144\nBlock size: 4096\n", "", nil)
+	return host
+}
+
+//startConfiguredDriver is starting a test driver on the host calling the cloud
+//through the client, with the command line arguments changed by configure.
+func startConfiguredDriver(t *testing.T, dir string, cloud *fakeCloud, client CloudAPI, host *fakeHost, configure func(args *CommandLineArgs)) *testDriver {
+
+	polling := defaultPollConfigs()
+	for _, config := range polling {
+		config.initialInterval = time.Millisecond
+		config.maxInterval = time.Millisecond
+		config.timeout = time.Second
+	}
+	metadataPath := filepath.Join(dir, "metadata")
+	mountPath := filepath.Join(dir, "mnt")
+	datacenterID, serverID := testDatacenterID, testServerID
+	size, diskType, missingVolumePolicy := 1, "HDD", missingVolumeKeep
+	leaseDuration := time.Duration(0)
+	args := CommandLineArgs{
+		metadataPath:        &metadataPath,
+		mountPath:           &mountPath,
+		datacenterID:        &datacenterID,
+		serverID:            &serverID,
+		size:                &size,
+		diskType:            &diskType,
+		missingVolumePolicy: &missingVolumePolicy,
+		leaseDuration:       &leaseDuration,
+		polling:             polling,
+		usage:               &usageConfig{},
+	}
+	configure(&args)
+
+	d, err := NewDriver(client, NewUtilitiesWithHost(host), args)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return &testDriver{Driver: d, cloud: cloud, host: host, dir: d.metadataPath}
+}
+
+//close is shutting the driver down and removes its directories.
+func (d *testDriver) close() {
+	d.Shutdown()
+	os.RemoveAll(filepath.Dir(d.dir))
+}
+
+//volumeID is returning the ID of a registered volume.
+func (d *testDriver) volumeID(t *testing.T, name string) string {
+	vol, ok := d.lookupVolume(name)
+	if !ok {
+		t.Fatalf("volume %s is not registered", name)
+	}
+	return vol.VolumeID
+}
+
+//formatted makes the device hold the filesystem of the volume.
+func (d *testDriver) formatted(volumeID string) {
+	d.host.setOutput("blkid -p -o export /dev/vdb", "UUID="+volumeID+"\nTYPE=ext4\nUSAGE=filesystem\n", "", nil)
+}
+
+//attachedTo is returning the server a volume is attached to in the fake cloud.
+func (d *testDriver) attachedTo(volumeID string) string {
+	d.cloud.Lock()
+	defer d.cloud.Unlock()
+	if vol, ok := d.cloud.volumes[volumeID]; ok {
+		return vol.ServerID
+	}
+	return ""
+}
+
+//executed reports whether a command line was executed on the host.
+func (d *testDriver) executed(commandLine string) bool {
+	return d.countExecuted(commandLine) > 0
+}
+
+//countExecuted is returning how often a command line was executed on the host.
+func (d *testDriver) countExecuted(commandLine string) int {
+	count := 0
+	for _, executed := range d.host.executed() {
+		if executed == commandLine {
+			count++
+		}
+	}
+	return count
+}
+
+//expectError fails the test unless the response has an error containing the text.
+func expectError(t *testing.T, resp volume.Response, text string) {
+	if resp.Err == "" || !strings.Contains(resp.Err, text) {
+		t.Fatalf("expected an error containing %q, got %q", text, resp.Err)
+	}
+}
+
+func TestCreateMountUnmountRemove(t *testing.T) {
+	d := newTestDriver(t)
+	defer d.close()
+
+	resp := d.Create(volume.Request{Name: "vol"})
+	if resp.Err != "" {
+		t.Fatal(resp.Err)
+	}
+	volumeID := d.volumeID(t, "vol")
+	if !d.executed("mkfs.ext4 -U " + volumeID + " -L vol /dev/vdb") {
+		t.Errorf("volume was not formatted, executed %q", d.host.executed())
+	}
+	if server := d.attachedTo(volumeID); server != "" {
+		t.Errorf("created volume is still attached to %s", server)
+	}
+	if _, err := os.Stat(filepath.Join(d.metadataPath, "vol")); err != nil {
+		t.Errorf("metadata of the volume was not written: %v", err)
+	}
+
+	d.formatted(volumeID)
+	resp = d.Mount(volume.MountRequest{Name: "vol", ID: "container"})
+	if resp.Err != "" {
+		t.Fatal(resp.Err)
+	}
+	if !d.executed("mount /dev/vdb " + resp.Mountpoint) {
+		t.Errorf("device was not mounted, executed %q", d.host.executed())
+	}
+	if server := d.attachedTo(volumeID); server != testServerID {
+		t.Errorf("mounted volume is attached to %q", server)
+	}
+	status := d.Get(volume.Request{Name: "vol"}).Volume.Status
+	if status["State"] != volumeStateMounted || status["DevicePath"] != "/dev/vdb" {
+		t.Errorf("mounted volume has status %v", status)
+	}
+
+	resp = d.Unmount(volume.UnmountRequest{Name: "vol", ID: "container"})
+	if resp.Err != "" {
+		t.Fatal(resp.Err)
+	}
+	if server := d.attachedTo(volumeID); server != "" {
+		t.Errorf("unmounted volume is still attached to %s", server)
+	}
+
+	resp = d.Remove(volume.Request{Name: "vol"})
+	if resp.Err != "" {
+		t.Fatal(resp.Err)
+	}
+	if _, err := d.cloud.GetVolume(testDatacenterID, volumeID); !isNotFound(err) {
+		t.Errorf("removed volume still exists: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(d.metadataPath, "vol")); !os.IsNotExist(err) {
+		t.Errorf("metadata of the removed volume still exists: %v", err)
+	}
+}
+
+func TestMountReferenceCounting(t *testing.T) {
+	cloud := newFakeCloud()
+	counting := &countingCloud{fakeCloud: cloud}
+	d := startLeasingDriver(t, testDir(t), cloud, counting, 0)
+	defer d.close()
+
+	if resp := d.Create(volume.Request{Name: "vol"}); resp.Err != "" {
+		t.Fatal(resp.Err)
+	}
+	volumeID := d.volumeID(t, "vol")
+	d.formatted(volumeID)
+	attaches, detaches := counting.attachments()
+
+	first := d.Mount(volume.MountRequest{Name: "vol", ID: "first"})
+	second := d.Mount(volume.MountRequest{Name: "vol", ID: "second"})
+	again := d.Mount(volume.MountRequest{Name: "vol", ID: "first"})
+	if first.Err != "" || second.Err != "" || again.Err != "" {
+		t.Fatalf("mounts failed: %q, %q, %q", first.Err, second.Err, again.Err)
+	}
+	if second.Mountpoint != first.Mountpoint || again.Mountpoint != first.Mountpoint {
+		t.Errorf("callers got the mountpoints %s, %s and %s", first.Mountpoint, second.Mountpoint, again.Mountpoint)
+	}
+	if attached, _ := counting.attachments(); attached != attaches+1 {
+		t.Errorf("volume mounted by two callers was attached %d times", attached-attaches)
+	}
+	if count := d.countExecuted("mount /dev/vdb " + first.Mountpoint); count != 1 {
+		t.Errorf("volume mounted by two callers was mounted %d times", count)
+	}
+
+	if resp := d.Unmount(volume.UnmountRequest{Name: "vol", ID: "first"}); resp.Err != "" {
+		t.Fatal(resp.Err)
+	}
+	if _, detached := counting.attachments(); detached != detaches || d.attachedTo(volumeID) != testServerID {
+		t.Error("volume was detached while another caller still has it mounted")
+	}
+	if d.executed("umount " + first.Mountpoint) {
+		t.Error("volume was unmounted while another caller still has it mounted")
+	}
+	state, err := d.readMetadata("vol")
+	if err != nil || !reflect.DeepEqual(state.Mounts, map[string]bool{"second": true}) {
+		t.Errorf("metadata holds the mounts %v: %v", state.Mounts, err)
+	}
+
+	if resp := d.Unmount(volume.UnmountRequest{Name: "vol", ID: "second"}); resp.Err != "" {
+		t.Fatal(resp.Err)
+	}
+	if _, detached := counting.attachments(); detached != detaches+1 || d.attachedTo(volumeID) != "" {
+		t.Errorf("volume was detached %d times after the last unmount", detached-detaches)
+	}
+	if !d.executed("umount " + first.Mountpoint) {
+		t.Errorf("volume was not unmounted after the last unmount, executed %q", d.host.executed())
+	}
+}
+
+func TestCreateExistingVolume(t *testing.T) {
+	cloud := newFakeCloud()
+	counting := &countingCloud{fakeCloud: cloud}
+	d := startLeasingDriver(t, testDir(t), cloud, counting, 0)
+	defer d.close()
+
+	options := map[string]string{"volume_size": "1", "volume_type": "HDD", fsTypeOption: filesystemExt4}
+	for i := 0; i < 2; i++ {
+		if resp := d.Create(volume.Request{Name: "vol", Options: options}); resp.Err != "" {
+			t.Fatalf("create %d failed: %s", i+1, resp.Err)
+		}
+	}
+	if created := counting.volumesCreated(); created != 1 {
+		t.Errorf("repeated create created %d volumes", created)
+	}
+
+	expectError(t, d.Create(volume.Request{Name: "vol", Options: map[string]string{"volume_size": "2"}}), "already exists with size 1 GB")
+	expectError(t, d.Create(volume.Request{Name: "vol", Options: map[string]string{"volume_type": "SSD"}}), "already exists with type HDD")
+	expectError(t, d.Create(volume.Request{Name: "vol", Options: map[string]string{fsTypeOption: filesystemXFS}}), "already exists with filesystem ext4")
+	if created := counting.volumesCreated(); created != 1 {
+		t.Errorf("conflicting creates created %d volumes", created-1)
+	}
+}
+
+func TestCreateVolumeOfOtherHost(t *testing.T) {
+	cloud := newFakeCloud()
+	first := startTestDriver(t, testDir(t), cloud)
+	defer first.close()
+	if resp := first.Create(volume.Request{Name: "vol"}); resp.Err != "" {
+		t.Fatal(resp.Err)
+	}
+
+	counting := &countingCloud{fakeCloud: cloud}
+	d := startLeasingDriver(t, testDir(t), cloud, counting, 0)
+	defer d.close()
+	expectError(t, d.Create(volume.Request{Name: "vol", Options: map[string]string{"volume_size": "2"}}), "already exists with size 1 GB")
+	if resp := d.Create(volume.Request{Name: "vol", Options: map[string]string{"volume_size": "1"}}); resp.Err != "" {
+		t.Fatal(resp.Err)
+	}
+	if created := counting.volumesCreated(); created != 0 {
+		t.Errorf("create of a volume of another host created %d volumes", created)
+	}
+	if d.volumeID(t, "vol") != first.volumeID(t, "vol") {
+		t.Error("create registered another volume than the one created by the other host")
+	}
+}
+
+func TestCreateFailedRequest(t *testing.T) {
+	d := newTestDriver(t)
+	defer d.close()
+
+	d.cloud.failRequest("CreateVolume", "out of capacity")
+	expectError(t, d.Create(volume.Request{Name: "vol"}), "out of capacity")
+	if _, ok := d.lookupVolume("vol"); ok {
+		t.Error("volume of a failed create is registered")
+	}
+	if volumes, _ := d.cloud.ListVolumes(testDatacenterID); len(volumes.Items) != 0 {
+		t.Errorf("failed create left volumes %v", volumes.Items)
+	}
+}
+
+func TestCreateRollsBackFailedAttach(t *testing.T) {
+	d := newTestDriver(t)
+	defer d.close()
+
+	d.cloud.failRequest("AttachVolume", "no free slot")
+	expectError(t, d.Create(volume.Request{Name: "vol"}), "no free slot")
+	if volumes, _ := d.cloud.ListVolumes(testDatacenterID); len(volumes.Items) != 0 {
+		t.Errorf("created volume was not deleted, left %v", volumes.Items)
+	}
+	if _, err := os.Stat(filepath.Join(d.metadataPath, "vol")); !os.IsNotExist(err) {
+		t.Errorf("metadata of the failed create exists: %v", err)
+	}
+}
+
+func TestCreateRefusesDeviceHoldingData(t *testing.T) {
+	d := newTestDriver(t)
+	defer d.close()
+
+	d.host.setOutput("blkid -p -o export /dev/vdb", "PTTYPE=dos\n", "", nil)
+	expectError(t, d.Create(volume.Request{Name: "vol"}), "Refusing to format device /dev/vdb")
+	if volumes, _ := d.cloud.ListVolumes(testDatacenterID); len(volumes.Items) != 0 {
+		t.Errorf("refused create left volumes %v", volumes.Items)
+	}
+}
+
+func TestCreateFromSnapshot(t *testing.T) {
+	d := newTestDriver(t)
+	defer d.close()
+
+	snapshotID := d.cloud.addSnapshot("backup")
+	d.host.setOutput("blkid -p -o export /dev/vdb", "UUID=old\nTYPE=ext4\nUSAGE=filesystem\n", "", nil)
+	resp := d.Create(volume.Request{Name: "restored", Options: map[string]string{"snapshot_name": "backup"}})
+	if resp.Err != "" {
+		t.Fatal(resp.Err)
+	}
+
+	volumeID := d.volumeID(t, "restored")
+	d.cloud.Lock()
+	image := d.cloud.volumes[volumeID].Volume.Properties.Image
+	d.cloud.Unlock()
+	if image != snapshotID {
+		t.Errorf("volume was created from image %q, expected snapshot %s", image, snapshotID)
+	}
+	if !d.executed("tune2fs /dev/vdb -U " + volumeID) {
+		t.Errorf("filesystem of the snapshot was not stamped, executed %q", d.host.executed())
+	}
+	for _, executed := range d.host.executed() {
+		if strings.HasPrefix(executed, "mkfs") {
+			t.Errorf("snapshot was formatted with %s", executed)
+		}
+	}
+}
+
+func TestCreateFromSnapshotID(t *testing.T) {
+	d := newTestDriver(t)
+	defer d.close()
+
+	snapshotID := d.cloud.addSnapshot("backup")
+	d.host.setOutput("blkid -p -o export /dev/vdb", "UUID=old\nTYPE=ext4\nUSAGE=filesystem\n", "", nil)
+	resp := d.Create(volume.Request{Name: "restored", Options: map[string]string{"snapshot_id": snapshotID}})
+	if resp.Err != "" {
+		t.Fatal(resp.Err)
+	}
+	if volumeID := d.volumeID(t, "restored"); !d.executed("tune2fs /dev/vdb -U " + volumeID) {
+		t.Errorf("filesystem of the snapshot was not stamped, executed %q", d.host.executed())
+	}
+}
+
+func TestCreateFromMissingSnapshot(t *testing.T) {
+	d := newTestDriver(t)
+	defer d.close()
+
+	expectError(t, d.Create(volume.Request{Name: "vol", Options: map[string]string{"snapshot_name": "unknown"}}), "could not be found")
+	expectError(t, d.Create(volume.Request{Name: "vol", Options: map[string]string{"snapshot_id": d.cloud.newID()}}), "could not be found")
+}
+
+func TestCreateWithDefaultsOfOtherFilesystem(t *testing.T) {
+	cloud := newFakeCloud()
+	d := startConfiguredDriver(t, testDir(t), cloud, cloud, newTestHost(), func(args *CommandLineArgs) {
+		mkfsOptions, mountOptions := "-E lazy_itable_init=0", "data=ordered"
+		args.mkfsOptions = &mkfsOptions
+		args.mountOptions = &mountOptions
+	})
+	defer d.close()
+
+	if resp := d.Create(volume.Request{Name: "xfsvol", Options: map[string]string{fsTypeOption: filesystemXFS}}); resp.Err != "" {
+		t.Fatal(resp.Err)
+	}
+	volumeID := d.volumeID(t, "xfsvol")
+	if !d.executed("mkfs.xfs -m uuid=" + volumeID + " -L xfsvol /dev/vdb") {
+		t.Errorf("xfs volume was not formatted without the ext4 defaults, executed %q", d.host.executed())
+	}
+	if state, _ := d.lookupVolume("xfsvol"); len(state.MountOptions) != 0 {
+		t.Errorf("xfs volume got the ext4 mount options %q", state.MountOptions)
+	}
+
+	if resp := d.Create(volume.Request{Name: "ext4vol"}); resp.Err != "" {
+		t.Fatal(resp.Err)
+	}
+	volumeID = d.volumeID(t, "ext4vol")
+	if !d.executed("mkfs.ext4 -U " + volumeID + " -L ext4vol -E lazy_itable_init=0 /dev/vdb") {
+		t.Errorf("ext4 volume was not formatted with the defaults, executed %q", d.host.executed())
+	}
+	if state, _ := d.lookupVolume("ext4vol"); !reflect.DeepEqual(state.MountOptions, []string{"data=ordered"}) {
+		t.Errorf("ext4 volume got the mount options %q", state.MountOptions)
+	}
+
+	d.cloud.addSnapshot("backup")
+	d.host.setOutput("blkid -p -o export /dev/vdb", "UUID=old\nTYPE=xfs\nUSAGE=filesystem\n", "", nil)
+	resp := d.Create(volume.Request{Name: "restored", Options: map[string]string{"snapshot_name": "backup", mkfsOptionsOption: "-J size=64"}})
+	if resp.Err != "" {
+		t.Fatal(resp.Err)
+	}
+	if state, _ := d.lookupVolume("restored"); state.Filesystem != filesystemXFS || len(state.MkfsOptions) != 0 || len(state.MountOptions) != 0 {
+		t.Errorf("snapshot got filesystem %s with mkfs options %q and mount options %q", state.Filesystem, state.MkfsOptions, state.MountOptions)
+	}
+}
+
+func TestMountAttachFailure(t *testing.T) {
+	d := newTestDriver(t)
+	defer d.close()
+
+	if resp := d.Create(volume.Request{Name: "vol"}); resp.Err != "" {
+		t.Fatal(resp.Err)
+	}
+	volumeID := d.volumeID(t, "vol")
+	d.formatted(volumeID)
+
+	d.cloud.failNext("AttachVolume", notFound("volume", volumeID))
+	expectError(t, d.Mount(volume.MountRequest{Name: "vol", ID: "container"}), "404")
+	d.cloud.failRequest("AttachVolume", "attach failed")
+	expectError(t, d.Mount(volume.MountRequest{Name: "vol", ID: "container"}), "attach failed")
+	if vol, _ := d.lookupVolume("vol"); len(vol.Mounts) != 0 {
+		t.Errorf("failed mount left references %v", vol.Mounts)
+	}
+
+	resp := d.Mount(volume.MountRequest{Name: "vol", ID: "container"})
+	if resp.Err != "" {
+		t.Fatalf("mount after failures failed: %s", resp.Err)
+	}
+}
+
+func TestMountRefusesForeignFilesystem(t *testing.T) {
+	d := newTestDriver(t)
+	defer d.close()
+
+	if resp := d.Create(volume.Request{Name: "vol"}); resp.Err != "" {
+		t.Fatal(resp.Err)
+	}
+	d.formatted("another-volume")
+	expectError(t, d.Mount(volume.MountRequest{Name: "vol", ID: "container"}), "expected the filesystem UUID")
+	for _, executed := range d.host.executed() {
+		if strings.HasPrefix(executed, "mount ") {
+			t.Errorf("foreign filesystem was mounted with %s", executed)
+		}
+	}
+}
+
+func TestMountTakeover(t *testing.T) {
+	d := newTestDriver(t)
+	defer d.close()
+
+	if resp := d.Create(volume.Request{Name: "vol"}); resp.Err != "" {
+		t.Fatal(resp.Err)
+	}
+	volumeID := d.volumeID(t, "vol")
+	d.formatted(volumeID)
+	d.cloud.Lock()
+	d.cloud.volumes[volumeID].ServerID = "other"
+	d.cloud.Unlock()
+
+	d.cloud.setServerState("other", "RUNNING")
+	d.invalidateRemoteVolumes()
+	expectError(t, d.Mount(volume.MountRequest{Name: "vol", ID: "container"}), "is RUNNING")
+	if server := d.attachedTo(volumeID); server != "other" {
+		t.Errorf("volume was taken over from a running server, attached to %q", server)
+	}
+
+	d.cloud.setServerState("other", "SHUTOFF")
+	resp := d.Mount(volume.MountRequest{Name: "vol", ID: "container"})
+	if resp.Err != "" {
+		t.Fatal(resp.Err)
+	}
+	if server := d.attachedTo(volumeID); server != testServerID {
+		t.Errorf("volume was not taken over, attached to %q", server)
+	}
+}
+
+func TestRemoveVolumeDeletedInDatacenter(t *testing.T) {
+	d := newTestDriver(t)
+	defer d.close()
+
+	if resp := d.Create(volume.Request{Name: "vol"}); resp.Err != "" {
+		t.Fatal(resp.Err)
+	}
+	volumeID := d.volumeID(t, "vol")
+	d.cloud.Lock()
+	delete(d.cloud.volumes, volumeID)
+	d.cloud.Unlock()
+
+	expectError(t, d.Remove(volume.Request{Name: "vol"}), "404")
+	expectError(t, d.Remove(volume.Request{Name: "unknown"}), "does not exist")
+}
+
+//statusOf is returning the status of a volume reported by Get and List, which
+//fail the test if they do not return within a second.
+func (d *testDriver) statusOf(t *testing.T, name string) (map[string]interface{}, map[string]interface{}) {
+	type result struct {
+		get  volume.Response
+		list volume.Response
+	}
+	done := make(chan result, 1)
+	go func() {
+		done <- result{get: d.Get(volume.Request{Name: name}), list: d.List(volume.Request{})}
+	}()
+
+	select {
+	case res := <-done:
+		if res.get.Err != "" || res.get.Volume == nil {
+			t.Fatalf("Get returned %+v", res.get)
+		}
+		for _, vol := range res.list.Volumes {
+			if vol.Name == name {
+				return res.get.Volume.Status, vol.Status
+			}
+		}
+		t.Fatalf("List returned %+v without volume %s", res.list, name)
+	case <-time.After(time.Second):
+		t.Fatal("Get and List waited for the operation holding the volume lock")
+	}
+	return nil, nil
+}
+
+func TestStatusWhileMounting(t *testing.T) {
+	cloud := newFakeCloud()
+	stalling := &stallingCloud{fakeCloud: cloud}
+	d := startLeasingDriver(t, testDir(t), cloud, stalling, 0)
+	defer d.close()
+
+	if resp := d.Create(volume.Request{Name: "vol"}); resp.Err != "" {
+		t.Fatal(resp.Err)
+	}
+	d.formatted(d.volumeID(t, "vol"))
+
+	stalling.stall("AttachVolume")
+	stalled, release := stalling.stalled, stalling.release
+	mounted := make(chan volume.Response, 1)
+	go func() {
+		mounted <- d.Mount(volume.MountRequest{Name: "vol", ID: "container"})
+	}()
+	<-stalled
+
+	//The mount holds the volume lock while it waits for the attachment
+	get, list := d.statusOf(t, "vol")
+	if get["State"] != volumeStateUnmounted || list["State"] != volumeStateUnmounted {
+		t.Errorf("volume being mounted has status %v from Get and %v from List", get, list)
+	}
+	select {
+	case resp := <-mounted:
+		t.Fatalf("mount returned %+v before the attachment was released", resp)
+	default:
+	}
+
+	close(release)
+	if resp := <-mounted; resp.Err != "" {
+		t.Fatal(resp.Err)
+	}
+	get, list = d.statusOf(t, "vol")
+	if get["State"] != volumeStateMounted || list["State"] != volumeStateMounted || get["References"] != 1 {
+		t.Errorf("mounted volume has status %v from Get and %v from List", get, list)
+	}
+}
+
+func TestWaitTillAvailable(t *testing.T) {
+	d := newTestDriver(t)
+	defer d.close()
+
+	volumeID := d.cloud.newID()
+	d.cloud.Lock()
+	d.cloud.volumes[volumeID] = &fakeVolume{
+		DatacenterID: testDatacenterID,
+		Volume:       profitbricks.Volume{ID: volumeID, Metadata: &profitbricks.Metadata{State: "BUSY"}},
+	}
+	d.cloud.Unlock()
+	d.polling[operationCreate].timeout = 50 * time.Millisecond
+
+	err := d.waitTillAvailable(operationCreate, volumeID)
+	if err == nil || !strings.Contains(err.Error(), "last status: BUSY") {
+		t.Fatalf("busy volume did not time out: %v", err)
+	}
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		d.cloud.Lock()
+		d.cloud.volumes[volumeID].Volume.Metadata = &profitbricks.Metadata{State: "AVAILABLE"}
+		d.cloud.Unlock()
+	}()
+	if err := d.waitTillAvailable(operationCreate, volumeID); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRemoveRefusesVolumeOfOtherServer(t *testing.T) {
+	d := newTestDriver(t)
+	defer d.close()
+
+	//The volume was created on the other server and is unknown here
+	volumeID := addVolume(d.cloud, taggedVolumeName("shared", nil), time.Now().UTC())
+	d.cloud.setServerState("other", "RUNNING")
+	d.cloud.Lock()
+	d.cloud.volumes[volumeID].ServerID = "other"
+	d.cloud.Unlock()
+
+	expectError(t, d.Remove(volume.Request{Name: "shared"}), "is attached to server 'other'")
+	if _, err := d.cloud.GetVolume(testDatacenterID, volumeID); err != nil {
+		t.Errorf("volume attached to another server was deleted: %v", err)
+	}
+	if server := d.attachedTo(volumeID); server != "other" {
+		t.Errorf("volume was detached from the other server, attached to %q", server)
+	}
+
+	d.cloud.Lock()
+	d.cloud.volumes[volumeID].ServerID = ""
+	d.cloud.Unlock()
+	if resp := d.Remove(volume.Request{Name: "shared"}); resp.Err != "" {
+		t.Fatalf("detached volume was not removed: %s", resp.Err)
+	}
+}
+
+//countingCloud is a CloudAPI counting the listings of servers.
+type countingCloud struct {
+	*fakeCloud
+	mutex       sync.Mutex
+	listServers int
+	creates     int
+	attaches    int
+	detaches    int
+}
+
+//CreateVolume is creating a volume.
+func (c *countingCloud) CreateVolume(dcid string, request profitbricks.Volume) (*profitbricks.Volume, error) {
+	c.mutex.Lock()
+	c.creates++
+	c.mutex.Unlock()
+	return c.fakeCloud.CreateVolume(dcid, request)
+}
+
+//volumesCreated is returning how often volumes were created.
+func (c *countingCloud) volumesCreated() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.creates
+}
+
+//AttachVolume is attaching a volume to a server.
+func (c *countingCloud) AttachVolume(dcid string, srvid string, volid string) (*profitbricks.Volume, error) {
+	c.mutex.Lock()
+	c.attaches++
+	c.mutex.Unlock()
+	return c.fakeCloud.AttachVolume(dcid, srvid, volid)
+}
+
+//DetachVolume is detaching a volume from a server.
+func (c *countingCloud) DetachVolume(dcid, srvid, volid string) (*http.Header, error) {
+	c.mutex.Lock()
+	c.detaches++
+	c.mutex.Unlock()
+	return c.fakeCloud.DetachVolume(dcid, srvid, volid)
+}
+
+//attachments is returning how often volumes were attached and detached.
+func (c *countingCloud) attachments() (int, int) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.attaches, c.detaches
+}
+
+//ListServers is listing servers of the datacenter.
+func (c *countingCloud) ListServers(dcid string) (*profitbricks.Servers, error) {
+	c.mutex.Lock()
+	c.listServers++
+	c.mutex.Unlock()
+	return c.fakeCloud.ListServers(dcid)
+}
+
+//serverListings is returning how often servers were listed.
+func (c *countingCloud) serverListings() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.listServers
+}
+
+func TestStatusCachesAttachments(t *testing.T) {
+	cloud := newFakeCloud()
+	counting := &countingCloud{fakeCloud: cloud}
+	d := startLeasingDriver(t, testDir(t), cloud, counting, 0)
+	defer d.close()
+
+	if resp := d.Create(volume.Request{Name: "vol"}); resp.Err != "" {
+		t.Fatal(resp.Err)
+	}
+	d.formatted(d.volumeID(t, "vol"))
+
+	listings := counting.serverListings()
+	for i := 0; i < 5; i++ {
+		d.Get(volume.Request{Name: "vol"})
+		d.List(volume.Request{})
+	}
+	if listed := counting.serverListings() - listings; listed != 1 {
+		t.Errorf("servers were listed %d times for repeated status reports", listed)
+	}
+
+	//Attachments changed by the driver are listed again
+	if resp := d.Mount(volume.MountRequest{Name: "vol", ID: "container"}); resp.Err != "" {
+		t.Fatal(resp.Err)
+	}
+	if status := d.Get(volume.Request{Name: "vol"}).Volume.Status; status["AttachedServer"] != testServerID {
+		t.Errorf("mounted volume has status %v", status)
+	}
+	if resp := d.Unmount(volume.UnmountRequest{Name: "vol", ID: "container"}); resp.Err != "" {
+		t.Fatal(resp.Err)
+	}
+	if status := d.Get(volume.Request{Name: "vol"}).Volume.Status; status["AttachedServer"] != nil {
+		t.Errorf("unmounted volume has status %v", status)
+	}
+}
+
+func TestMountGrowsInBackground(t *testing.T) {
+	d := newTestDriver(t)
+	defer d.close()
+
+	if resp := d.Create(volume.Request{Name: "vol"}); resp.Err != "" {
+		t.Fatal(resp.Err)
+	}
+	d.formatted(d.volumeID(t, "vol"))
+	//The volume was resized while it was not mounted, the device still has the old size
+	vol, _ := d.lookupVolume("vol")
+	d.updateVolume(func() {
+		vol.Size = 2
+		vol.GrowPending = true
+	})
+
+	if resp := d.Mount(volume.MountRequest{Name: "vol", ID: "container"}); resp.Err != "" {
+		t.Fatal(resp.Err)
+	}
+	if status := d.Get(volume.Request{Name: "vol"}).Volume.Status; status["GrowPending"] != true {
+		t.Errorf("volume waiting for its device to grow has status %v", status)
+	}
+
+	d.host.setOutput("blockdev --getsize64 /dev/vdb", "2147483648\n", "", nil)
+	deadline := time.Now().Add(time.Second)
+	for d.Get(volume.Request{Name: "vol"}).Volume.Status["GrowPending"] == true {
+		if time.Now().After(deadline) {
+			t.Fatal("filesystem was not grown in the background")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if !d.executed("resize2fs /dev/vdb") {
+		t.Errorf("filesystem was not grown, executed %q", d.host.executed())
+	}
+}
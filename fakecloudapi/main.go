@@ -0,0 +1,44 @@
+//Command fakecloudapi serves an in-memory subset of the ProfitBricks Cloud API
+//used by the docker volume plugin, so the plugin can run without a real account.
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+
+	log "github.com/Sirupsen/logrus"
+	flag "github.com/ogier/pflag"
+)
+
+func main() {
+	listen := flag.StringP("listen", "a", "127.0.0.1:8080", "the address to listen on")
+	basePath := flag.String("base-path", "", "the path prefix of the API, e.g. /cloudapi/v4")
+	latency := flag.Duration("latency", 0, "the latency added to every response")
+	requestDuration := flag.Duration("request-duration", 0, "the time a request needs to go through QUEUED and RUNNING to DONE")
+	errorRate := flag.Float64("error-rate", 0, "the ratio of calls answered with HTTP 503")
+	failRate := flag.Float64("fail-rate", 0, "the ratio of requests ending with the FAILED status")
+	logLevel := flag.StringP("log-level", "l", "info", "log level")
+	flag.Parse()
+
+	level, err := log.ParseLevel(*logLevel)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	log.SetLevel(level)
+
+	srv := newServer(Options{
+		BasePath:        *basePath,
+		Latency:         *latency,
+		RequestDuration: *requestDuration,
+		ErrorRate:       *errorRate,
+		FailRate:        *failRate,
+	})
+
+	log.Infof("Listening on %s", *listen)
+	err = http.ListenAndServe(*listen, srv)
+	if err != nil {
+		log.Fatalf("failed to listen on %s: %v", *listen, err)
+	}
+}
@@ -0,0 +1,619 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/profitbricks/profitbricks-sdk-go"
+)
+
+//Request statuses reported by the Cloud API.
+const (
+	statusQueued  = "QUEUED"
+	statusRunning = "RUNNING"
+	statusDone    = "DONE"
+	statusFailed  = "FAILED"
+)
+
+//Options represent the behaviour of the fake server.
+type Options struct {
+	BasePath        string
+	Latency         time.Duration
+	RequestDuration time.Duration
+	ErrorRate       float64
+	FailRate        float64
+}
+
+//failure represents an injected failure.
+type failure struct {
+	Method string `json:"method"`
+	Path   string `json:"path"`
+	//Status is a http status returned instead of handling the call.
+	Status int `json:"status"`
+	//Message makes the request created by the call end as FAILED.
+	Message string `json:"message"`
+	Count   int    `json:"count"`
+}
+
+//request represents a long running request.
+type request struct {
+	id      string
+	created time.Time
+	failed  bool
+	message string
+	done    bool
+	apply   func()
+}
+
+//server represents an in-memory ProfitBricks Cloud API.
+type server struct {
+	sync.Mutex
+	options     Options
+	datacenters map[string]*profitbricks.Datacenter
+	servers     map[string]map[string]*profitbricks.Server
+	volumes     map[string]map[string]*profitbricks.Volume
+	attachments map[string]string
+	snapshots   map[string]*profitbricks.Snapshot
+	requests    map[string]*request
+	failures    []*failure
+	random      *rand.Rand
+}
+
+//newServer is a constructor of the fake server.
+func newServer(options Options) *server {
+	return &server{
+		options:     options,
+		datacenters: make(map[string]*profitbricks.Datacenter),
+		servers:     make(map[string]map[string]*profitbricks.Server),
+		volumes:     make(map[string]map[string]*profitbricks.Volume),
+		attachments: make(map[string]string),
+		snapshots:   make(map[string]*profitbricks.Snapshot),
+		requests:    make(map[string]*request),
+		random:      rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+//apiError represents an error body of the Cloud API.
+type apiError struct {
+	HTTPStatus int          `json:"httpStatus"`
+	Messages   []apiMessage `json:"messages"`
+}
+
+//apiMessage represents a single error message of the Cloud API.
+type apiMessage struct {
+	ErrorCode string `json:"errorCode"`
+	Message   string `json:"message"`
+}
+
+//writeError is writing an error in the Cloud API format.
+func writeError(w http.ResponseWriter, status int, format string, a ...interface{}) {
+	writeJSON(w, status, apiError{
+		HTTPStatus: status,
+		Messages:   []apiMessage{{ErrorCode: fmt.Sprint(status), Message: fmt.Sprintf(format, a...)}},
+	})
+}
+
+//writeJSON is writing a json body.
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.Errorf("failed to encode response: %v", err)
+	}
+}
+
+//newID is generating a random uuid.
+func (s *server) newID() string {
+	b := make([]byte, 16)
+	s.random.Read(b)
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+//ServeHTTP is routing a call to the resource handlers.
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.options.Latency > 0 {
+		time.Sleep(s.options.Latency)
+	}
+	log.Infof("%s %s", r.Method, r.URL.Path)
+
+	path := strings.TrimPrefix(r.URL.Path, s.options.BasePath)
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+
+	if parts[0] == "_fake" {
+		s.handleAdmin(w, r, parts[1:])
+		return
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	s.advance()
+
+	injected := s.takeFailure(r.Method, path)
+	if injected != nil && injected.Status != 0 {
+		writeError(w, injected.Status, "injected failure for %s %s", r.Method, path)
+		return
+	}
+	if injected == nil && s.options.ErrorRate > 0 && s.random.Float64() < s.options.ErrorRate {
+		writeError(w, http.StatusServiceUnavailable, "injected random failure for %s %s", r.Method, path)
+		return
+	}
+	failMessage := ""
+	if injected != nil {
+		failMessage = injected.Message
+	}
+
+	switch {
+	case parts[0] == "datacenters":
+		s.handleDatacenters(w, r, parts[1:], failMessage)
+	case parts[0] == "snapshots":
+		s.handleSnapshots(w, r, parts[1:], failMessage)
+	case parts[0] == "requests" && len(parts) == 3 && parts[2] == "status":
+		s.handleRequestStatus(w, r, parts[1])
+	default:
+		writeError(w, http.StatusNotFound, "resource %s does not exist", path)
+	}
+}
+
+//handleAdmin is handling failure injection of the fake server.
+func (s *server) handleAdmin(w http.ResponseWriter, r *http.Request, parts []string) {
+	if len(parts) != 1 || parts[0] != "failures" {
+		writeError(w, http.StatusNotFound, "unknown admin resource")
+		return
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, s.failures)
+	case http.MethodPost:
+		f := &failure{}
+		if err := json.NewDecoder(r.Body).Decode(f); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid failure: %v", err)
+			return
+		}
+		if f.Count == 0 {
+			f.Count = 1
+		}
+		s.failures = append(s.failures, f)
+		writeJSON(w, http.StatusCreated, f)
+	case http.MethodDelete:
+		s.failures = nil
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method %s is not allowed", r.Method)
+	}
+}
+
+//takeFailure is returning an injected failure matching the call.
+func (s *server) takeFailure(method string, path string) *failure {
+	for i, f := range s.failures {
+		if (f.Method == "" || f.Method == method) && strings.HasPrefix(path, f.Path) {
+			f.Count--
+			if f.Count <= 0 {
+				s.failures = append(s.failures[:i], s.failures[i+1:]...)
+			}
+			return f
+		}
+	}
+	return nil
+}
+
+//newRequest is registering a long running request and writes its Location header.
+func (s *server) newRequest(w http.ResponseWriter, r *http.Request, failMessage string, apply func()) {
+	req := &request{
+		id:      s.newID(),
+		created: time.Now(),
+		apply:   apply,
+	}
+	if failMessage != "" {
+		req.failed = true
+		req.message = failMessage
+	} else if s.options.FailRate > 0 && s.random.Float64() < s.options.FailRate {
+		req.failed = true
+		req.message = "injected random request failure"
+	}
+	s.requests[req.id] = req
+	s.advance()
+
+	w.Header().Set("Location", fmt.Sprintf("http://%s%s/requests/%s/status", r.Host, s.options.BasePath, req.id))
+}
+
+//status is computing the current status of a request.
+func (s *server) status(req *request) string {
+	elapsed := time.Since(req.created)
+	switch {
+	case elapsed < s.options.RequestDuration/3:
+		return statusQueued
+	case elapsed < s.options.RequestDuration:
+		return statusRunning
+	case req.failed:
+		return statusFailed
+	}
+	return statusDone
+}
+
+//advance is applying changes of requests that are DONE.
+func (s *server) advance() {
+	for _, req := range s.requests {
+		if !req.done && s.status(req) == statusDone {
+			req.done = true
+			if req.apply != nil {
+				req.apply()
+			}
+		}
+	}
+}
+
+//handleRequestStatus is returning a request status.
+func (s *server) handleRequestStatus(w http.ResponseWriter, r *http.Request, id string) {
+	req, ok := s.requests[id]
+	if !ok {
+		writeError(w, http.StatusNotFound, "request %s does not exist", id)
+		return
+	}
+
+	status := &profitbricks.RequestStatus{ID: id}
+	status.Metadata.Status = s.status(req)
+	if status.Metadata.Status == statusFailed {
+		status.Metadata.Message = req.message
+	}
+	writeJSON(w, http.StatusOK, status)
+}
+
+//datacenter is returning a datacenter, creating it on the first reference.
+func (s *server) datacenter(id string) *profitbricks.Datacenter {
+	dc, ok := s.datacenters[id]
+	if !ok {
+		dc = &profitbricks.Datacenter{
+			ID:         id,
+			PBType:     "datacenter",
+			Properties: profitbricks.DatacenterProperties{Name: id, Location: "de/fra"},
+		}
+		s.datacenters[id] = dc
+		s.servers[id] = make(map[string]*profitbricks.Server)
+		s.volumes[id] = make(map[string]*profitbricks.Volume)
+	}
+	return dc
+}
+
+//server is returning a server, creating it on the first reference.
+func (s *server) server(dcid string, id string) *profitbricks.Server {
+	s.datacenter(dcid)
+	srv, ok := s.servers[dcid][id]
+	if !ok {
+		srv = &profitbricks.Server{
+			ID:         id,
+			PBType:     "server",
+			Metadata:   &profitbricks.Metadata{State: "AVAILABLE"},
+			Properties: profitbricks.ServerProperties{Name: id, VMState: "RUNNING"},
+		}
+		s.servers[dcid][id] = srv
+	}
+	return srv
+}
+
+//handleDatacenters is handling datacenter resources.
+func (s *server) handleDatacenters(w http.ResponseWriter, r *http.Request, parts []string, failMessage string) {
+	if len(parts) == 0 || parts[0] == "" {
+		datacenters := &profitbricks.Datacenters{}
+		for _, dc := range s.datacenters {
+			datacenters.Items = append(datacenters.Items, *dc)
+		}
+		writeJSON(w, http.StatusOK, datacenters)
+		return
+	}
+
+	dcid := parts[0]
+	if len(parts) == 1 {
+		writeJSON(w, http.StatusOK, s.datacenter(dcid))
+		return
+	}
+
+	s.datacenter(dcid)
+	switch parts[1] {
+	case "volumes":
+		s.handleVolumes(w, r, dcid, parts[2:], failMessage)
+	case "servers":
+		s.handleServers(w, r, dcid, parts[2:], failMessage)
+	default:
+		writeError(w, http.StatusNotFound, "resource %s does not exist", parts[1])
+	}
+}
+
+//handleVolumes is handling volume resources of a datacenter.
+func (s *server) handleVolumes(w http.ResponseWriter, r *http.Request, dcid string, parts []string, failMessage string) {
+	volumes := s.volumes[dcid]
+	if len(parts) == 0 {
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, http.StatusOK, s.listVolumes(volumes, ""))
+		case http.MethodPost:
+			s.createVolume(w, r, dcid, failMessage)
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "method %s is not allowed", r.Method)
+		}
+		return
+	}
+
+	vol, ok := volumes[parts[0]]
+	if !ok {
+		writeError(w, http.StatusNotFound, "volume %s does not exist", parts[0])
+		return
+	}
+
+	if len(parts) == 2 && parts[1] == "create-snapshot" && r.Method == http.MethodPost {
+		s.createSnapshot(w, r, vol, failMessage)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, vol)
+	case http.MethodPatch:
+		props := profitbricks.VolumeProperties{}
+		if err := json.NewDecoder(r.Body).Decode(&props); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid volume properties: %v", err)
+			return
+		}
+		if props.Size != 0 && props.Size < vol.Properties.Size {
+			writeError(w, http.StatusUnprocessableEntity, "volume size can not be decreased")
+			return
+		}
+		s.newRequest(w, r, failMessage, func() {
+			if props.Name != "" {
+				vol.Properties.Name = props.Name
+			}
+			if props.Size != 0 {
+				vol.Properties.Size = props.Size
+			}
+		})
+		writeJSON(w, http.StatusAccepted, vol)
+	case http.MethodDelete:
+		if serverID, ok := s.attachments[vol.ID]; ok {
+			writeError(w, http.StatusUnprocessableEntity, "volume %s is attached to server %s", vol.ID, serverID)
+			return
+		}
+		s.newRequest(w, r, failMessage, func() {
+			delete(volumes, vol.ID)
+		})
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method %s is not allowed", r.Method)
+	}
+}
+
+//listVolumes is listing volumes, optionally only the ones attached to a server.
+func (s *server) listVolumes(volumes map[string]*profitbricks.Volume, serverID string) *profitbricks.Volumes {
+	ret := &profitbricks.Volumes{Items: []profitbricks.Volume{}}
+	for _, vol := range volumes {
+		if serverID == "" || s.attachments[vol.ID] == serverID {
+			ret.Items = append(ret.Items, *vol)
+		}
+	}
+	return ret
+}
+
+//createVolume is creating a volume.
+func (s *server) createVolume(w http.ResponseWriter, r *http.Request, dcid string, failMessage string) {
+	vol := &profitbricks.Volume{}
+	if err := json.NewDecoder(r.Body).Decode(vol); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid volume: %v", err)
+		return
+	}
+	if vol.Properties.Size <= 0 {
+		writeError(w, http.StatusUnprocessableEntity, "volume size has to be positive")
+		return
+	}
+	if vol.Properties.Type != "HDD" && vol.Properties.Type != "SSD" {
+		writeError(w, http.StatusUnprocessableEntity, "volume type %q is not supported", vol.Properties.Type)
+		return
+	}
+	if vol.Properties.Image != "" {
+		if _, ok := s.snapshots[vol.Properties.Image]; !ok {
+			writeError(w, http.StatusNotFound, "snapshot %s does not exist", vol.Properties.Image)
+			return
+		}
+	}
+
+	vol.ID = s.newID()
+	vol.PBType = "volume"
+	vol.Metadata = &profitbricks.Metadata{CreatedDate: time.Now(), State: "BUSY"}
+	if vol.Properties.AvailabilityZone == "" {
+		vol.Properties.AvailabilityZone = "AUTO"
+	}
+	s.newRequest(w, r, failMessage, func() {
+		vol.Metadata.State = "AVAILABLE"
+		s.volumes[dcid][vol.ID] = vol
+	})
+	writeJSON(w, http.StatusAccepted, vol)
+}
+
+//createSnapshot is creating a snapshot of a volume.
+func (s *server) createSnapshot(w http.ResponseWriter, r *http.Request, vol *profitbricks.Volume, failMessage string) {
+	//The SDK sends form values without a content type.
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid snapshot: %v", err)
+		return
+	}
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid snapshot: %v", err)
+		return
+	}
+
+	snapshot := &profitbricks.Snapshot{
+		ID:     s.newID(),
+		PBType: "snapshot",
+		Properties: profitbricks.SnapshotProperties{
+			Name:        form.Get("name"),
+			Description: form.Get("description"),
+			Size:        vol.Properties.Size,
+			LicenceType: vol.Properties.LicenceType,
+		},
+	}
+	s.newRequest(w, r, failMessage, func() {
+		s.snapshots[snapshot.ID] = snapshot
+	})
+	writeJSON(w, http.StatusAccepted, snapshot)
+}
+
+//handleServers is handling server resources of a datacenter.
+func (s *server) handleServers(w http.ResponseWriter, r *http.Request, dcid string, parts []string, failMessage string) {
+	if len(parts) == 0 {
+		servers := &profitbricks.Servers{}
+		for _, srv := range s.servers[dcid] {
+			servers.Items = append(servers.Items, *s.withEntities(dcid, srv))
+		}
+		writeJSON(w, http.StatusOK, servers)
+		return
+	}
+
+	srv := s.server(dcid, parts[0])
+	if len(parts) == 1 {
+		writeJSON(w, http.StatusOK, s.withEntities(dcid, srv))
+		return
+	}
+	if len(parts) == 2 && r.Method == http.MethodPost && (parts[1] == "stop" || parts[1] == "start") {
+		vmState := "RUNNING"
+		if parts[1] == "stop" {
+			vmState = "SHUTOFF"
+		}
+		s.newRequest(w, r, failMessage, func() {
+			srv.Properties.VMState = vmState
+		})
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	if parts[1] != "volumes" {
+		writeError(w, http.StatusNotFound, "resource %s does not exist", parts[1])
+		return
+	}
+
+	volumes := s.volumes[dcid]
+	if len(parts) == 2 {
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, http.StatusOK, s.listVolumes(volumes, srv.ID))
+		case http.MethodPost:
+			s.attachVolume(w, r, dcid, srv, failMessage)
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "method %s is not allowed", r.Method)
+		}
+		return
+	}
+
+	vol, ok := volumes[parts[2]]
+	if !ok || s.attachments[parts[2]] != srv.ID {
+		writeError(w, http.StatusNotFound, "volume %s is not attached to server %s", parts[2], srv.ID)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, vol)
+	case http.MethodDelete:
+		s.newRequest(w, r, failMessage, func() {
+			delete(s.attachments, vol.ID)
+			vol.Properties.DeviceNumber = 0
+		})
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method %s is not allowed", r.Method)
+	}
+}
+
+//withEntities is returning a server including its attached volumes.
+func (s *server) withEntities(dcid string, srv *profitbricks.Server) *profitbricks.Server {
+	ret := *srv
+	ret.Entities = &profitbricks.ServerEntities{Volumes: s.listVolumes(s.volumes[dcid], srv.ID)}
+	return &ret
+}
+
+//attachVolume is attaching a volume to a server.
+func (s *server) attachVolume(w http.ResponseWriter, r *http.Request, dcid string, srv *profitbricks.Server, failMessage string) {
+	ref := profitbricks.ResourceReference{}
+	if err := json.NewDecoder(r.Body).Decode(&ref); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid volume reference: %v", err)
+		return
+	}
+
+	vol, ok := s.volumes[dcid][ref.ID]
+	if !ok {
+		writeError(w, http.StatusNotFound, "volume %s does not exist", ref.ID)
+		return
+	}
+	if serverID, ok := s.attachments[vol.ID]; ok {
+		writeError(w, http.StatusUnprocessableEntity, "volume %s is already attached to server %s", vol.ID, serverID)
+		return
+	}
+
+	s.newRequest(w, r, failMessage, func() {
+		s.attachments[vol.ID] = srv.ID
+		vol.Properties.Bus = "VIRTIO"
+		vol.Properties.DeviceNumber = s.nextDeviceNumber(srv.ID)
+	})
+	writeJSON(w, http.StatusAccepted, vol)
+}
+
+//nextDeviceNumber is returning the lowest free device number of a server.
+//Device number 1 is reserved for the boot volume.
+func (s *server) nextDeviceNumber(serverID string) int64 {
+	used := make(map[int64]bool)
+	for volumeID, attachedTo := range s.attachments {
+		if attachedTo != serverID {
+			continue
+		}
+		for _, volumes := range s.volumes {
+			if vol, ok := volumes[volumeID]; ok {
+				used[vol.Properties.DeviceNumber] = true
+			}
+		}
+	}
+	number := int64(2)
+	for used[number] {
+		number++
+	}
+	return number
+}
+
+//handleSnapshots is handling snapshot resources.
+func (s *server) handleSnapshots(w http.ResponseWriter, r *http.Request, parts []string, failMessage string) {
+	if len(parts) == 0 || parts[0] == "" {
+		snapshots := &profitbricks.Snapshots{Items: []profitbricks.Snapshot{}}
+		for _, snapshot := range s.snapshots {
+			snapshots.Items = append(snapshots.Items, *snapshot)
+		}
+		writeJSON(w, http.StatusOK, snapshots)
+		return
+	}
+
+	snapshot, ok := s.snapshots[parts[0]]
+	if !ok {
+		writeError(w, http.StatusNotFound, "snapshot %s does not exist", parts[0])
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, snapshot)
+	case http.MethodDelete:
+		s.newRequest(w, r, failMessage, func() {
+			delete(s.snapshots, snapshot.ID)
+		})
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method %s is not allowed", r.Method)
+	}
+}
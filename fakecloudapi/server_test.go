@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/profitbricks/profitbricks-sdk-go"
+)
+
+//testServer is a fake server listening on a local address.
+type testServer struct {
+	*server
+	http *httptest.Server
+}
+
+//newTestServer is starting a fake server.
+func newTestServer(options Options) *testServer {
+	s := newServer(options)
+	return &testServer{server: s, http: httptest.NewServer(s)}
+}
+
+//call is sending a call to the fake server and decodes the response body into out.
+func (s *testServer) call(t *testing.T, method string, path string, in interface{}, out interface{}) *http.Response {
+	var body []byte
+	if in != nil {
+		var err error
+		if body, err = json.Marshal(in); err != nil {
+			t.Fatal(err)
+		}
+	}
+	req, err := http.NewRequest(method, s.http.URL+path, bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != nil && len(data) > 0 {
+		if err := json.Unmarshal(data, out); err != nil {
+			t.Fatalf("%s %s returned %q: %v", method, path, data, err)
+		}
+	}
+	return resp
+}
+
+//createVolume is creating a volume in the datacenter and returns its id.
+func (s *testServer) createVolume(t *testing.T, name string) (string, *http.Response) {
+	vol := &profitbricks.Volume{}
+	resp := s.call(t, http.MethodPost, "/datacenters/dc/volumes", profitbricks.Volume{
+		Properties: profitbricks.VolumeProperties{Name: name, Size: 1, Type: "HDD"},
+	}, vol)
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("creating volume %s returned %s", name, resp.Status)
+	}
+	return vol.ID, resp
+}
+
+//requestStatus is returning the status of the request the response refers to.
+func (s *testServer) requestStatus(t *testing.T, resp *http.Response) *profitbricks.RequestStatus {
+	location := resp.Header.Get("Location")
+	if !strings.HasPrefix(location, s.http.URL) {
+		t.Fatalf("request location %q is not served by the fake server", location)
+	}
+	status := &profitbricks.RequestStatus{}
+	if resp := s.call(t, http.MethodGet, strings.TrimPrefix(location, s.http.URL), nil, status); resp.StatusCode != http.StatusOK {
+		t.Fatalf("request status returned %s", resp.Status)
+	}
+	return status
+}
+
+//age is moving the creation of the request the response refers to into the past.
+func (s *testServer) age(resp *http.Response, d time.Duration) {
+	id := path.Base(path.Dir(resp.Header.Get("Location")))
+	s.Lock()
+	defer s.Unlock()
+	s.requests[id].created = s.requests[id].created.Add(-d)
+}
+
+//volumeNames is listing the names of the volumes in the datacenter.
+func (s *testServer) volumeNames(t *testing.T) []string {
+	volumes := &profitbricks.Volumes{}
+	s.call(t, http.MethodGet, "/datacenters/dc/volumes", nil, volumes)
+	names := []string{}
+	for _, vol := range volumes.Items {
+		names = append(names, vol.Properties.Name)
+	}
+	return names
+}
+
+func TestRequestStatus(t *testing.T) {
+	s := newTestServer(Options{RequestDuration: 3 * time.Hour})
+	defer s.http.Close()
+
+	_, resp := s.createVolume(t, "vol")
+	for _, step := range []struct {
+		age    time.Duration
+		status string
+	}{
+		{0, statusQueued},
+		{time.Hour, statusRunning},
+		{2 * time.Hour, statusDone},
+	} {
+		s.age(resp, step.age)
+		if status := s.requestStatus(t, resp); status.Metadata.Status != step.status {
+			t.Errorf("request is %s, expected %s", status.Metadata.Status, step.status)
+		}
+		names := s.volumeNames(t)
+		if created := len(names) == 1; created != (step.status == statusDone) {
+			t.Errorf("%s request has the volumes %v", step.status, names)
+		}
+	}
+
+	if resp := s.call(t, http.MethodGet, "/requests/unknown/status", nil, nil); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("status of an unknown request returned %s", resp.Status)
+	}
+}
+
+func TestFailedRequest(t *testing.T) {
+	s := newTestServer(Options{RequestDuration: time.Hour})
+	defer s.http.Close()
+
+	s.call(t, http.MethodPost, "/_fake/failures", failure{Method: http.MethodPost, Path: "/datacenters/dc/volumes", Message: "no capacity"}, nil)
+	_, resp := s.createVolume(t, "vol")
+	if status := s.requestStatus(t, resp); status.Metadata.Status != statusQueued {
+		t.Errorf("failing request is %s before it ran", status.Metadata.Status)
+	}
+	s.age(resp, time.Hour)
+	status := s.requestStatus(t, resp)
+	if status.Metadata.Status != statusFailed || status.Metadata.Message != "no capacity" {
+		t.Errorf("failing request is %s with message %q", status.Metadata.Status, status.Metadata.Message)
+	}
+	if names := s.volumeNames(t); len(names) != 0 {
+		t.Errorf("failed request created the volumes %v", names)
+	}
+}
+
+func TestInjectedFailures(t *testing.T) {
+	s := newTestServer(Options{})
+	defer s.http.Close()
+
+	injected := &failure{}
+	resp := s.call(t, http.MethodPost, "/_fake/failures", failure{Method: http.MethodGet, Path: "/datacenters/dc/volumes", Status: http.StatusServiceUnavailable, Count: 2}, injected)
+	if resp.StatusCode != http.StatusCreated || injected.Count != 2 {
+		t.Fatalf("injecting a failure returned %s with %+v", resp.Status, injected)
+	}
+	if resp := s.call(t, http.MethodPost, "/_fake/failures", failure{Path: "/snapshots", Status: http.StatusUnauthorized}, injected); injected.Count != 1 {
+		t.Errorf("failure without count is injected %d times: %s", injected.Count, resp.Status)
+	}
+	failures := []*failure{}
+	if s.call(t, http.MethodGet, "/_fake/failures", nil, &failures); len(failures) != 2 {
+		t.Errorf("listed the injected failures %+v", failures)
+	}
+
+	//Other methods and paths are not affected
+	if resp := s.call(t, http.MethodGet, "/datacenters/dc", nil, nil); resp.StatusCode != http.StatusOK {
+		t.Errorf("call without injected failure returned %s", resp.Status)
+	}
+	if _, resp := s.createVolume(t, "vol"); resp.StatusCode != http.StatusAccepted {
+		t.Errorf("call with other method returned %s", resp.Status)
+	}
+	for i, expected := range []int{http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusOK} {
+		apiErr := &apiError{}
+		resp := s.call(t, http.MethodGet, "/datacenters/dc/volumes", nil, apiErr)
+		if resp.StatusCode != expected {
+			t.Errorf("call %d returned %s, expected %d", i+1, resp.Status, expected)
+		}
+		if expected != http.StatusOK && (apiErr.HTTPStatus != expected || len(apiErr.Messages) != 1) {
+			t.Errorf("call %d returned the error %+v", i+1, apiErr)
+		}
+	}
+
+	if resp := s.call(t, http.MethodDelete, "/_fake/failures", nil, nil); resp.StatusCode != http.StatusNoContent {
+		t.Errorf("clearing the failures returned %s", resp.Status)
+	}
+	if resp := s.call(t, http.MethodGet, "/snapshots", nil, nil); resp.StatusCode != http.StatusOK {
+		t.Errorf("call of a cleared failure returned %s", resp.Status)
+	}
+}
+
+//attach is attaching a volume to a server and returns the http status.
+func (s *testServer) attach(t *testing.T, serverID string, volumeID string) int {
+	return s.call(t, http.MethodPost, "/datacenters/dc/servers/"+serverID+"/volumes", profitbricks.ResourceReference{ID: volumeID}, nil).StatusCode
+}
+
+//deviceNumber is returning the device number of a volume attached to a server.
+func (s *testServer) deviceNumber(t *testing.T, serverID string, volumeID string) int64 {
+	vol := &profitbricks.Volume{}
+	if resp := s.call(t, http.MethodGet, "/datacenters/dc/servers/"+serverID+"/volumes/"+volumeID, nil, vol); resp.StatusCode != http.StatusOK {
+		t.Fatalf("volume %s is not attached to server %s: %s", volumeID, serverID, resp.Status)
+	}
+	return vol.Properties.DeviceNumber
+}
+
+func TestAttachVolume(t *testing.T) {
+	s := newTestServer(Options{})
+	defer s.http.Close()
+
+	first, _ := s.createVolume(t, "first")
+	second, _ := s.createVolume(t, "second")
+	third, _ := s.createVolume(t, "third")
+	for _, volumeID := range []string{first, second} {
+		if status := s.attach(t, "srv", volumeID); status != http.StatusAccepted {
+			t.Fatalf("attaching volume %s returned %d", volumeID, status)
+		}
+	}
+	if first, second := s.deviceNumber(t, "srv", first), s.deviceNumber(t, "srv", second); first != 2 || second != 3 {
+		t.Errorf("volumes were attached as device %d and %d, expected 2 and 3", first, second)
+	}
+
+	//Conflicts
+	if status := s.attach(t, "srv", first); status != http.StatusUnprocessableEntity {
+		t.Errorf("attaching an attached volume again returned %d", status)
+	}
+	if status := s.attach(t, "other", first); status != http.StatusUnprocessableEntity {
+		t.Errorf("attaching a volume to a second server returned %d", status)
+	}
+	if status := s.attach(t, "srv", "unknown"); status != http.StatusNotFound {
+		t.Errorf("attaching an unknown volume returned %d", status)
+	}
+
+	//The device number of a detached volume is reused
+	if resp := s.call(t, http.MethodDelete, "/datacenters/dc/servers/srv/volumes/"+first, nil, nil); resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("detaching returned %s", resp.Status)
+	}
+	if resp := s.call(t, http.MethodGet, "/datacenters/dc/servers/srv/volumes/"+first, nil, nil); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("detached volume is still listed at the server: %s", resp.Status)
+	}
+	if status := s.attach(t, "srv", third); status != http.StatusAccepted {
+		t.Fatalf("attaching volume %s returned %d", third, status)
+	}
+	if number := s.deviceNumber(t, "srv", third); number != 2 {
+		t.Errorf("volume was attached as device %d instead of the free device 2", number)
+	}
+	if status := s.attach(t, "other", first); status != http.StatusAccepted {
+		t.Errorf("attaching the detached volume to another server returned %d", status)
+	}
+	if number := s.deviceNumber(t, "other", first); number != 2 {
+		t.Errorf("volume was attached to another server as device %d, expected 2", number)
+	}
+
+	srv := &profitbricks.Server{}
+	s.call(t, http.MethodGet, "/datacenters/dc/servers/srv", nil, srv)
+	if srv.Entities == nil || srv.Entities.Volumes == nil || len(srv.Entities.Volumes.Items) != 2 {
+		t.Errorf("server lists the volumes %+v", srv.Entities)
+	}
+}
+
+func TestDeleteAttachedVolume(t *testing.T) {
+	s := newTestServer(Options{})
+	defer s.http.Close()
+
+	volumeID, _ := s.createVolume(t, "vol")
+	if status := s.attach(t, "srv", volumeID); status != http.StatusAccepted {
+		t.Fatalf("attaching returned %d", status)
+	}
+	if resp := s.call(t, http.MethodDelete, "/datacenters/dc/volumes/"+volumeID, nil, nil); resp.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("deleting an attached volume returned %s", resp.Status)
+	}
+	if names := s.volumeNames(t); len(names) != 1 {
+		t.Errorf("attached volume was deleted, the datacenter has the volumes %v", names)
+	}
+
+	s.call(t, http.MethodDelete, "/datacenters/dc/servers/srv/volumes/"+volumeID, nil, nil)
+	if resp := s.call(t, http.MethodDelete, "/datacenters/dc/volumes/"+volumeID, nil, nil); resp.StatusCode != http.StatusAccepted {
+		t.Errorf("deleting a detached volume returned %s", resp.Status)
+	}
+	if names := s.volumeNames(t); len(names) != 0 {
+		t.Errorf("detached volume was not deleted, the datacenter has the volumes %v", names)
+	}
+}
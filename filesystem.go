@@ -0,0 +1,423 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+//Constances used by filesystems.
+const (
+	//fsTypeOption selects the filesystem a new volume is formatted with.
+	fsTypeOption = "fs_type"
+
+	//Filesystem types supported for volumes.
+	filesystemExt4  = "ext4"
+	filesystemXFS   = "xfs"
+	filesystemBtrfs = "btrfs"
+)
+
+//Patterns of the filesystem sizes printed by the filesystem tools.
+var (
+	xfsDataSection = regexp.MustCompile(`data\s+=\s+bsize=(\d+)\s+blocks=(\d+)`)
+	btrfsDevice    = regexp.MustCompile(`devid\s+\d+\s+size\s+(\d+)`)
+)
+
+//Filesystem represents the operations the driver executes on a filesystem
+//type. The volume ID is stamped as the filesystem UUID, so the device of a
+//volume is verified before it is mounted.
+type Filesystem interface {
+	//Name is returning the type of the filesystem.
+	Name() string
+	//MkfsOptions is returning the flags allowed for formatting, mapped to
+	//whether they take a value. Flags set by the plugin are not allowed.
+	MkfsOptions() map[string]bool
+	//MountOptions is returning the mount options specific to the filesystem,
+	//mapped to whether they take a value.
+	MountOptions() map[string]bool
+	//Format is creating the filesystem on a device with the UUID, the label
+	//and the validated mkfs options.
+	Format(devicePath string, uuid string, label string, options []string) error
+	//SetUUID is changing the UUID of the unmounted filesystem on a device.
+	SetUUID(devicePath string, uuid string) error
+	//SetLabel is changing the label of the unmounted filesystem on a device.
+	SetLabel(devicePath string, label string) error
+	//Check is checking the unmounted filesystem on a device and repairs it if safe.
+	Check(devicePath string) error
+	//Grow is growing a mounted filesystem to the size of its device.
+	Grow(devicePath string, mountPoint string) error
+	//Size is returning the size of a mounted filesystem in bytes, including
+	//the space taken by its metadata.
+	Size(devicePath string, mountPoint string) (uint64, error)
+}
+
+//supportedFilesystems is returning the supported filesystem types.
+func supportedFilesystems() []string {
+	return []string{filesystemExt4, filesystemXFS, filesystemBtrfs}
+}
+
+//newFilesystem is returning the operations of a filesystem type.
+func newFilesystem(name string, host Host) (Filesystem, error) {
+	switch name {
+	case filesystemExt4:
+		return ext4Filesystem{host: host}, nil
+	case filesystemXFS:
+		return xfsFilesystem{host: host}, nil
+	case filesystemBtrfs:
+		return btrfsFilesystem{host: host}, nil
+	}
+	return nil, fmt.Errorf("Unsupported filesystem %q, use one of %s", name, strings.Join(supportedFilesystems(), ", "))
+}
+
+//run is executing a filesystem tool and wraps its stderr into the error, or
+//the error itself if the tool is missing.
+func run(host Host, action string, devicePath string, name string, args ...string) (string, error) {
+	stdOut, stdErr, err := host.Run(name, args...)
+	if err != nil {
+		if stdErr == "" {
+			stdErr = err.Error()
+		}
+		return stdOut, fmt.Errorf("Error occurred while %s %s: %s", action, devicePath, stdErr)
+	}
+	return stdOut, nil
+}
+
+//truncateLabel is shortening a label to the length the filesystem supports.
+func truncateLabel(label string, length int) string {
+	if len(label) > length {
+		return label[:length]
+	}
+	return label
+}
+
+//ext4Filesystem is managing ext4 filesystems with e2fsprogs.
+type ext4Filesystem struct {
+	host Host
+}
+
+//Name is returning ext4.
+func (f ext4Filesystem) Name() string {
+	return filesystemExt4
+}
+
+//MkfsOptions is returning the mke2fs flags tuning the block size, inodes,
+//reserved blocks, features and journal.
+func (f ext4Filesystem) MkfsOptions() map[string]bool {
+	return map[string]bool{
+		"-b": true,
+		"-C": true,
+		"-E": true,
+		"-g": true,
+		"-G": true,
+		"-i": true,
+		"-I": true,
+		"-J": true,
+		"-j": false,
+		"-m": true,
+		"-N": true,
+		"-O": true,
+		"-T": true,
+	}
+}
+
+//MountOptions is returning the ext4 mount options.
+func (f ext4Filesystem) MountOptions() map[string]bool {
+	return map[string]bool{
+		"acl":                  false,
+		"auto_da_alloc":        false,
+		"barrier":              true,
+		"commit":               true,
+		"data":                 true,
+		"delalloc":             false,
+		"dioread_lock":         false,
+		"dioread_nolock":       false,
+		"discard":              false,
+		"errors":               true,
+		"inode_readahead_blks": true,
+		"journal_async_commit": false,
+		"journal_checksum":     false,
+		"max_batch_time":       true,
+		"min_batch_time":       true,
+		"noacl":                false,
+		"noauto_da_alloc":      false,
+		"nobarrier":            false,
+		"nodelalloc":           false,
+		"nodiscard":            false,
+		"stripe":               true,
+		"user_xattr":           false,
+	}
+}
+
+//Format is running mkfs.ext4.
+func (f ext4Filesystem) Format(devicePath string, uuid string, label string, options []string) error {
+	log.Infof("Formating volume %s with ext4 and uuid %s", devicePath, uuid)
+	args := append([]string{"-U", uuid, "-L", truncateLabel(label, 16)}, options...)
+	_, err := run(f.host, "formatting", devicePath, "mkfs.ext4", append(args, devicePath)...)
+	return err
+}
+
+//SetUUID is running tune2fs.
+func (f ext4Filesystem) SetUUID(devicePath string, uuid string) error {
+	log.Infof("Tuning volume %s with uuid %s", devicePath, uuid)
+	_, err := run(f.host, "tuning", devicePath, "tune2fs", devicePath, "-U", uuid)
+	return err
+}
+
+//SetLabel is running e2label.
+func (f ext4Filesystem) SetLabel(devicePath string, label string) error {
+	_, err := run(f.host, "labeling", devicePath, "e2label", devicePath, truncateLabel(label, 16))
+	return err
+}
+
+//Check is running e2fsck, which fixes what is safe to fix without asking.
+func (f ext4Filesystem) Check(devicePath string) error {
+	log.Infof("Checking ext4 filesystem on %s", devicePath)
+	_, stdErr, err := f.host.Run("e2fsck", "-f", "-p", devicePath)
+	//Exit code 1 means errors were corrected
+	if exitCode(err) == 1 {
+		log.Warnf("Errors of the ext4 filesystem on %s were corrected", devicePath)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("Error occurred while checking %s: %s", devicePath, stdErr)
+	}
+	return nil
+}
+
+//Grow is running resize2fs, which grows mounted filesystems online.
+func (f ext4Filesystem) Grow(devicePath string, mountPoint string) error {
+	_, err := run(f.host, "growing the filesystem on", devicePath, "resize2fs", devicePath)
+	return err
+}
+
+//Size is reading the block count from the superblock.
+func (f ext4Filesystem) Size(devicePath string, mountPoint string) (uint64, error) {
+	stdOut, err := run(f.host, "reading the superblock of", devicePath, "dumpe2fs", "-h", devicePath)
+	if err != nil {
+		return 0, err
+	}
+
+	var blocks, blockSize uint64
+	for _, line := range strings.Split(stdOut, "\n") {
+		fields := strings.SplitN(line, ":", 2)
+		if len(fields) != 2 {
+			continue
+		}
+		switch fields[0] {
+		case "Block count":
+			blocks, _ = strconv.ParseUint(strings.TrimSpace(fields[1]), 10, 64)
+		case "Block size":
+			blockSize, _ = strconv.ParseUint(strings.TrimSpace(fields[1]), 10, 64)
+		}
+	}
+	if blocks == 0 || blockSize == 0 {
+		return 0, fmt.Errorf("Size of the filesystem on %s could not be found", devicePath)
+	}
+	return blocks * blockSize, nil
+}
+
+//xfsFilesystem is managing XFS filesystems with xfsprogs.
+type xfsFilesystem struct {
+	host Host
+}
+
+//Name is returning xfs.
+func (f xfsFilesystem) Name() string {
+	return filesystemXFS
+}
+
+//MkfsOptions is returning the mkfs.xfs flags tuning the sections of the
+//filesystem. The metadata flag is not allowed, the plugin sets the UUID with it.
+func (f xfsFilesystem) MkfsOptions() map[string]bool {
+	return map[string]bool{
+		"-b": true,
+		"-d": true,
+		"-i": true,
+		"-K": false,
+		"-l": true,
+		"-n": true,
+		"-r": true,
+		"-s": true,
+	}
+}
+
+//MountOptions is returning the xfs mount options.
+func (f xfsFilesystem) MountOptions() map[string]bool {
+	return map[string]bool{
+		"allocsize":   true,
+		"discard":     false,
+		"filestreams": false,
+		"gquota":      false,
+		"grpquota":    false,
+		"inode32":     false,
+		"inode64":     false,
+		"largeio":     false,
+		"logbsize":    true,
+		"logbufs":     true,
+		"noalign":     false,
+		"nodiscard":   false,
+		"nolargeio":   false,
+		"noquota":     false,
+		"pquota":      false,
+		"prjquota":    false,
+		"sunit":       true,
+		"swalloc":     false,
+		"swidth":      true,
+		"uquota":      false,
+		"usrquota":    false,
+		"wsync":       false,
+	}
+}
+
+//Format is running mkfs.xfs.
+func (f xfsFilesystem) Format(devicePath string, uuid string, label string, options []string) error {
+	log.Infof("Formating volume %s with xfs and uuid %s", devicePath, uuid)
+	args := append([]string{"-m", "uuid=" + uuid, "-L", truncateLabel(label, 12)}, options...)
+	_, err := run(f.host, "formatting", devicePath, "mkfs.xfs", append(args, devicePath)...)
+	return err
+}
+
+//SetUUID is running xfs_admin.
+func (f xfsFilesystem) SetUUID(devicePath string, uuid string) error {
+	log.Infof("Tuning volume %s with uuid %s", devicePath, uuid)
+	_, err := run(f.host, "tuning", devicePath, "xfs_admin", "-U", uuid, devicePath)
+	return err
+}
+
+//SetLabel is running xfs_admin.
+func (f xfsFilesystem) SetLabel(devicePath string, label string) error {
+	_, err := run(f.host, "labeling", devicePath, "xfs_admin", "-L", truncateLabel(label, 12), devicePath)
+	return err
+}
+
+//Check is running xfs_repair without modifying the filesystem, XFS repairs
+//itself by replaying its log on mount.
+func (f xfsFilesystem) Check(devicePath string) error {
+	log.Infof("Checking xfs filesystem on %s", devicePath)
+	_, err := run(f.host, "checking", devicePath, "xfs_repair", "-n", devicePath)
+	return err
+}
+
+//Grow is running xfs_growfs, which only grows mounted filesystems.
+func (f xfsFilesystem) Grow(devicePath string, mountPoint string) error {
+	_, err := run(f.host, "growing the filesystem on", devicePath, "xfs_growfs", mountPoint)
+	return err
+}
+
+//Size is reading the data section of the geometry.
+func (f xfsFilesystem) Size(devicePath string, mountPoint string) (uint64, error) {
+	stdOut, err := run(f.host, "reading the geometry of", devicePath, "xfs_info", mountPoint)
+	if err != nil {
+		return 0, err
+	}
+
+	match := xfsDataSection.FindStringSubmatch(stdOut)
+	if match == nil {
+		return 0, fmt.Errorf("Size of the filesystem on %s could not be found", devicePath)
+	}
+	blockSize, _ := strconv.ParseUint(match[1], 10, 64)
+	blocks, _ := strconv.ParseUint(match[2], 10, 64)
+	return blocks * blockSize, nil
+}
+
+//btrfsFilesystem is managing btrfs filesystems with btrfs-progs.
+type btrfsFilesystem struct {
+	host Host
+}
+
+//Name is returning btrfs.
+func (f btrfsFilesystem) Name() string {
+	return filesystemBtrfs
+}
+
+//MkfsOptions is returning the mkfs.btrfs flags tuning the node size, the
+//profiles and the features.
+func (f btrfsFilesystem) MkfsOptions() map[string]bool {
+	return map[string]bool{
+		"-d": true,
+		"-K": false,
+		"-m": true,
+		"-M": false,
+		"-n": true,
+		"-O": true,
+		"-R": true,
+		"-s": true,
+	}
+}
+
+//MountOptions is returning the btrfs mount options. Subvolumes are not
+//selectable, a volume always mounts its top level.
+func (f btrfsFilesystem) MountOptions() map[string]bool {
+	return map[string]bool{
+		"autodefrag":      false,
+		"commit":          true,
+		"compress":        true,
+		"compress-force":  true,
+		"datacow":         false,
+		"datasum":         false,
+		"discard":         false,
+		"flushoncommit":   false,
+		"max_inline":      true,
+		"noautodefrag":    false,
+		"nodatacow":       false,
+		"nodatasum":       false,
+		"nodiscard":       false,
+		"noflushoncommit": false,
+		"nossd":           false,
+		"space_cache":     true,
+		"ssd":             false,
+		"ssd_spread":      false,
+		"thread_pool":     true,
+	}
+}
+
+//Format is running mkfs.btrfs.
+func (f btrfsFilesystem) Format(devicePath string, uuid string, label string, options []string) error {
+	log.Infof("Formating volume %s with btrfs and uuid %s", devicePath, uuid)
+	args := append([]string{"-U", uuid, "-L", truncateLabel(label, 255)}, options...)
+	_, err := run(f.host, "formatting", devicePath, "mkfs.btrfs", append(args, devicePath)...)
+	return err
+}
+
+//SetUUID is running btrfstune, which rewrites the UUID in every metadata block.
+func (f btrfsFilesystem) SetUUID(devicePath string, uuid string) error {
+	log.Infof("Tuning volume %s with uuid %s", devicePath, uuid)
+	_, err := run(f.host, "tuning", devicePath, "btrfstune", "-f", "-U", uuid, devicePath)
+	return err
+}
+
+//SetLabel is running btrfs filesystem label.
+func (f btrfsFilesystem) SetLabel(devicePath string, label string) error {
+	_, err := run(f.host, "labeling", devicePath, "btrfs", "filesystem", "label", devicePath, truncateLabel(label, 255))
+	return err
+}
+
+//Check is running btrfs check without modifying the filesystem.
+func (f btrfsFilesystem) Check(devicePath string) error {
+	log.Infof("Checking btrfs filesystem on %s", devicePath)
+	_, err := run(f.host, "checking", devicePath, "btrfs", "check", "--readonly", devicePath)
+	return err
+}
+
+//Grow is running btrfs filesystem resize, which only grows mounted filesystems.
+func (f btrfsFilesystem) Grow(devicePath string, mountPoint string) error {
+	_, err := run(f.host, "growing the filesystem on", devicePath, "btrfs", "filesystem", "resize", "max", mountPoint)
+	return err
+}
+
+//Size is reading the size of the only device of the filesystem.
+func (f btrfsFilesystem) Size(devicePath string, mountPoint string) (uint64, error) {
+	stdOut, err := run(f.host, "reading the devices of", devicePath, "btrfs", "filesystem", "show", "--raw", mountPoint)
+	if err != nil {
+		return 0, err
+	}
+
+	match := btrfsDevice.FindStringSubmatch(stdOut)
+	if match == nil {
+		return 0, fmt.Errorf("Size of the filesystem on %s could not be found", devicePath)
+	}
+	return strconv.ParseUint(match[1], 10, 64)
+}
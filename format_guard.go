@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+//forceFormatOption makes a new volume to be formatted even if its device holds data.
+const forceFormatOption = "force_format"
+
+//deviceSignature represents what was found on a device, like blkid reports it,
+//e.g. TYPE=LVM2_member and USAGE=raid.
+type deviceSignature map[string]string
+
+//blank reports whether nothing was found on the device.
+func (s deviceSignature) blank() bool {
+	return len(s) == 0
+}
+
+//String is returning the signature as sorted KEY=value pairs.
+func (s deviceSignature) String() string {
+	if s.blank() {
+		return "no signatures"
+	}
+	pairs := make([]string, 0, len(s))
+	for k, v := range s {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, " ")
+}
+
+//checkBlankDevice is refusing to format the device discovered for a new volume
+//when it holds a filesystem, a partition table, a RAID or LVM member, or is in
+//use by another device, as the wrong device might have been discovered.
+func (d *Driver) checkBlankDevice(name string, devicePath string, force bool) error {
+	signature, err := d.utilities.ProbeDevice(devicePath)
+	if err != nil {
+		return fmt.Errorf("Refusing to format device %s of volume %q, it could not be probed: %v", devicePath, name, err)
+	}
+	log.Infof("Probed device %s of volume %s: %v", devicePath, name, signature)
+	if signature.blank() {
+		return nil
+	}
+
+	if force {
+		log.Warnf("Formatting device %s of volume %s holding %v, as %s is set", devicePath, name, signature, forceFormatOption)
+		return nil
+	}
+	return fmt.Errorf("Refusing to format device %s of volume %q holding %v, set the %s option to format it anyway", devicePath, name, signature, forceFormatOption)
+}
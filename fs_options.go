@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+//Constances used by mkfs and mount options.
+const (
+	//mkfsOptionsOption holds the mkfs flags of a new volume separated by spaces, e.g. "-i 8192 -m 1".
+	mkfsOptionsOption = "mkfs_options"
+	//mountOptionsOption holds the mount options of a volume separated by commas, e.g. "noatime,discard".
+	mountOptionsOption = "mount_options"
+)
+
+//optionValue matches the values accepted for mkfs flags and mount options,
+//nothing a shell or the mount option parser would interpret.
+var optionValue = regexp.MustCompile(`^[A-Za-z0-9_.:/^+=,-]+$`)
+
+//mkfsDeviceSubOptions are the mkfs sub-options naming another device, file
+//or offset, which would let a volume format something other than its device.
+var mkfsDeviceSubOptions = map[string]bool{
+	"device": true,
+	"file":   true,
+	"logdev": true,
+	"name":   true,
+	"offset": true,
+	"rtdev":  true,
+}
+
+//commonMountOptions are the mount options supported by every filesystem,
+//mapped to whether they take a value.
+var commonMountOptions = map[string]bool{
+	"async":       false,
+	"dirsync":     false,
+	"lazytime":    false,
+	"noatime":     false,
+	"nodev":       false,
+	"nodiratime":  false,
+	"noexec":      false,
+	"nolazytime":  false,
+	"nosuid":      false,
+	"relatime":    false,
+	"ro":          false,
+	"rw":          false,
+	"strictatime": false,
+	"sync":        false,
+}
+
+//filesystemOptions represents the options a volume is formatted and mounted with.
+type filesystemOptions struct {
+	mkfs  []string
+	mount []string
+}
+
+//parseMkfsOptions is splitting mkfs flags separated by spaces and checks them
+//against the flags the filesystem allows.
+func parseMkfsOptions(filesystem Filesystem, value string) ([]string, error) {
+	allowed := filesystem.MkfsOptions()
+	args := strings.Fields(value)
+	for i := 0; i < len(args); i++ {
+		takesValue, ok := allowed[args[i]]
+		if !ok {
+			return nil, fmt.Errorf("mkfs option %q is not allowed for %s filesystems", args[i], filesystem.Name())
+		}
+		if !takesValue {
+			continue
+		}
+		i++
+		if i == len(args) || strings.HasPrefix(args[i], "-") || !optionValue.MatchString(args[i]) {
+			return nil, fmt.Errorf("mkfs option %q of %s filesystems needs a valid value", args[i-1], filesystem.Name())
+		}
+		if err := checkMkfsSubOptions(args[i]); err != nil {
+			return nil, fmt.Errorf("mkfs option %q of %s filesystems %v", args[i-1], filesystem.Name(), err)
+		}
+	}
+	return args, nil
+}
+
+//checkMkfsSubOptions is checking that a value of comma separated mkfs
+//sub-options names no path, device or offset outside the volume.
+func checkMkfsSubOptions(value string) error {
+	if strings.Contains(value, "/") {
+		return fmt.Errorf("must not name a path: %q", value)
+	}
+	for _, subOption := range strings.Split(value, ",") {
+		name := strings.SplitN(subOption, "=", 2)[0]
+		if mkfsDeviceSubOptions[name] {
+			return fmt.Errorf("must not set %q", name)
+		}
+	}
+	return nil
+}
+
+//parseMountOptions is splitting mount options separated by commas and checks
+//them against the options the filesystem allows.
+func parseMountOptions(filesystem Filesystem, value string) ([]string, error) {
+	allowed := filesystem.MountOptions()
+	options := []string{}
+	for _, option := range strings.Split(value, ",") {
+		option = strings.TrimSpace(option)
+		if option == "" {
+			continue
+		}
+
+		name := option
+		parts := strings.SplitN(option, "=", 2)
+		if len(parts) == 2 {
+			name = parts[0]
+		}
+		takesValue, ok := commonMountOptions[name]
+		if !ok {
+			takesValue, ok = allowed[name]
+		}
+		if !ok {
+			return nil, fmt.Errorf("mount option %q is not allowed for %s filesystems", name, filesystem.Name())
+		}
+		switch {
+		case takesValue && (len(parts) != 2 || !optionValue.MatchString(parts[1])):
+			return nil, fmt.Errorf("mount option %q of %s filesystems needs a valid value", name, filesystem.Name())
+		case !takesValue && len(parts) == 2:
+			return nil, fmt.Errorf("mount option %q of %s filesystems takes no value", name, filesystem.Name())
+		}
+		options = append(options, option)
+	}
+	return options, nil
+}
+
+//resolveFilesystemOptions is returning the options requested for a volume,
+//checked against the filesystem. The plugin-wide defaults were checked against
+//the default filesystem and only apply to volumes having it. The mkfs options
+//are only resolved for volumes being formatted.
+func (d *Driver) resolveFilesystemOptions(requested map[string]string, filesystem Filesystem, formatting bool) (filesystemOptions, error) {
+	var options filesystemOptions
+	useDefaults := filesystem.Name() == d.filesystem
+
+	if formatting {
+		mkfs, ok := requested[mkfsOptionsOption]
+		if !ok && useDefaults {
+			mkfs = d.mkfsOptions
+		}
+		parsed, err := parseMkfsOptions(filesystem, mkfs)
+		if err != nil {
+			return options, err
+		}
+		options.mkfs = parsed
+	}
+
+	mount, ok := requested[mountOptionsOption]
+	if !ok && useDefaults {
+		mount = d.mountOptions
+	}
+	parsed, err := parseMountOptions(filesystem, mount)
+	if err != nil {
+		return options, err
+	}
+	options.mount = parsed
+	return options, nil
+}
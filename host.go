@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+)
+
+//Host represents operations the driver executes on the host system.
+type Host interface {
+	//Run is executing a command and returns its stdout and stderr.
+	Run(name string, args ...string) (string, string, error)
+	//ReadFile is reading a file, e.g. from sysfs or procfs.
+	ReadFile(path string) ([]byte, error)
+	//WriteFile is writing a file, e.g. to sysfs.
+	WriteFile(path string, data []byte) error
+	//Statfs is returning statistics of the filesystem mounted at the path.
+	Statfs(path string) (*syscall.Statfs_t, error)
+	//ReadDir is returning the names of the entries of a directory, e.g. in sysfs.
+	ReadDir(path string) ([]string, error)
+	//EvalSymlinks is returning the path a symbolic link, like a udev link, resolves to.
+	EvalSymlinks(path string) (string, error)
+	//Exists reports whether a file, directory or device node exists.
+	Exists(path string) bool
+}
+
+//exitCode is returning the exit code of a command run by the host, or -1 if
+//it was not run or killed.
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok {
+			return status.ExitStatus()
+		}
+	}
+	return -1
+}
+
+//systemHost executes operations on the real host.
+type systemHost struct {
+}
+
+//Run is executing a command on the host.
+func (h systemHost) Run(name string, args ...string) (string, string, error) {
+	var stdOut, stdErr bytes.Buffer
+	cmd := exec.Command(name, args...)
+	cmd.Stdout = &stdOut
+	cmd.Stderr = &stdErr
+	err := cmd.Run()
+	return stdOut.String(), stdErr.String(), err
+}
+
+//ReadFile is reading a file from the host.
+func (h systemHost) ReadFile(path string) ([]byte, error) {
+	return ioutil.ReadFile(path)
+}
+
+//WriteFile is writing a file on the host.
+func (h systemHost) WriteFile(path string, data []byte) error {
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+//Statfs is returning statistics of a filesystem on the host.
+func (h systemHost) Statfs(path string) (*syscall.Statfs_t, error) {
+	stat := &syscall.Statfs_t{}
+	err := syscall.Statfs(path, stat)
+	if err != nil {
+		return nil, err
+	}
+	return stat, nil
+}
+
+//ReadDir is returning the names of the entries of a directory on the host.
+func (h systemHost) ReadDir(path string) ([]string, error) {
+	files, err := ioutil.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(files))
+	for _, file := range files {
+		names = append(names, file.Name())
+	}
+	return names, nil
+}
+
+//EvalSymlinks is resolving symbolic links on the host.
+func (h systemHost) EvalSymlinks(path string) (string, error) {
+	return filepath.EvalSymlinks(path)
+}
+
+//Exists reports whether a path exists on the host.
+func (h systemHost) Exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
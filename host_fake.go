@@ -0,0 +1,209 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+//fakeOutput represents a canned result of a command.
+type fakeOutput struct {
+	stdout string
+	stderr string
+	err    error
+}
+
+//fakeHost is a Host recording executed commands and returning canned results.
+type fakeHost struct {
+	sync.Mutex
+	commands []string
+	outputs  map[string]fakeOutput
+	files    map[string][]byte
+	statfs   map[string]*syscall.Statfs_t
+	//links maps symbolic links to their targets.
+	links map[string]string
+}
+
+//newFakeHost is a constructor of the recording Host.
+func newFakeHost() *fakeHost {
+	return &fakeHost{
+		outputs: make(map[string]fakeOutput),
+		files:   make(map[string][]byte),
+		statfs:  make(map[string]*syscall.Statfs_t),
+		links:   make(map[string]string),
+	}
+}
+
+//setOutput is setting the result of a command. The command is matched by its
+//full command line first and by its name afterwards.
+func (h *fakeHost) setOutput(command string, stdout string, stderr string, err error) {
+	h.Lock()
+	defer h.Unlock()
+	h.outputs[command] = fakeOutput{stdout: stdout, stderr: stderr, err: err}
+}
+
+//setFile is setting the content of a file.
+func (h *fakeHost) setFile(path string, content string) {
+	h.Lock()
+	defer h.Unlock()
+	h.files[path] = []byte(content)
+}
+
+//setLink is making the path a symbolic link to the target.
+func (h *fakeHost) setLink(path string, target string) {
+	h.Lock()
+	defer h.Unlock()
+	h.links[path] = target
+}
+
+//removeFile is removing a file or link set before.
+func (h *fakeHost) removeFile(path string) {
+	h.Lock()
+	defer h.Unlock()
+	delete(h.files, path)
+	delete(h.links, path)
+}
+
+//setStatfs is setting the statistics of the filesystem mounted at the path.
+func (h *fakeHost) setStatfs(path string, stat *syscall.Statfs_t) {
+	h.Lock()
+	defer h.Unlock()
+	h.statfs[path] = stat
+}
+
+//executed is returning command lines executed so far.
+func (h *fakeHost) executed() []string {
+	h.Lock()
+	defer h.Unlock()
+	return append([]string{}, h.commands...)
+}
+
+//Run is recording a command and returns its canned result.
+func (h *fakeHost) Run(name string, args ...string) (string, string, error) {
+	h.Lock()
+	defer h.Unlock()
+	commandLine := strings.Join(append([]string{name}, args...), " ")
+	h.commands = append(h.commands, commandLine)
+
+	if output, ok := h.outputs[commandLine]; ok {
+		return output.stdout, output.stderr, output.err
+	}
+	if output, ok := h.outputs[name]; ok {
+		return output.stdout, output.stderr, output.err
+	}
+	return "", "", nil
+}
+
+//ReadFile is returning the content of a file set by setFile.
+func (h *fakeHost) ReadFile(path string) ([]byte, error) {
+	h.Lock()
+	defer h.Unlock()
+	content, ok := h.files[path]
+	if !ok {
+		return nil, &os.PathError{Op: "open", Path: path, Err: os.ErrNotExist}
+	}
+	return content, nil
+}
+
+//WriteFile is setting the content of a file.
+func (h *fakeHost) WriteFile(path string, data []byte) error {
+	h.Lock()
+	defer h.Unlock()
+	h.files[path] = append([]byte{}, data...)
+	return nil
+}
+
+//resolve is following links till a path which is no link. The caller has to hold the lock.
+func (h *fakeHost) resolve(path string) string {
+	for i := 0; i < 40; i++ {
+		target, ok := h.links[path]
+		if !ok {
+			return path
+		}
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(filepath.Dir(path), target)
+		}
+		path = target
+	}
+	return path
+}
+
+//exists reports whether a file is set at the path or below it. The caller has to hold the lock.
+func (h *fakeHost) exists(path string) bool {
+	if _, ok := h.files[path]; ok {
+		return true
+	}
+	for _, name := range h.paths() {
+		if strings.HasPrefix(name, path+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+//paths is returning the paths of the files and links set. The caller has to hold the lock.
+func (h *fakeHost) paths() []string {
+	paths := []string{}
+	for name := range h.files {
+		paths = append(paths, name)
+	}
+	for name := range h.links {
+		paths = append(paths, name)
+	}
+	return paths
+}
+
+//ReadDir is returning the names of the files and links set directly below the path.
+func (h *fakeHost) ReadDir(path string) ([]string, error) {
+	h.Lock()
+	defer h.Unlock()
+	path = h.resolve(path)
+	found := make(map[string]bool)
+	for _, name := range h.paths() {
+		if strings.HasPrefix(name, path+"/") {
+			found[strings.SplitN(strings.TrimPrefix(name, path+"/"), "/", 2)[0]] = true
+		}
+	}
+	if len(found) == 0 {
+		return nil, &os.PathError{Op: "open", Path: path, Err: os.ErrNotExist}
+	}
+
+	names := []string{}
+	for name := range found {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+//EvalSymlinks is following the links set by setLink.
+func (h *fakeHost) EvalSymlinks(path string) (string, error) {
+	h.Lock()
+	defer h.Unlock()
+	resolved := h.resolve(path)
+	if !h.exists(resolved) {
+		return "", &os.PathError{Op: "lstat", Path: path, Err: os.ErrNotExist}
+	}
+	return resolved, nil
+}
+
+//Exists reports whether a file is set at the path, following links.
+func (h *fakeHost) Exists(path string) bool {
+	h.Lock()
+	defer h.Unlock()
+	return h.exists(h.resolve(path))
+}
+
+//Statfs is returning the statistics set by setStatfs.
+func (h *fakeHost) Statfs(path string) (*syscall.Statfs_t, error) {
+	h.Lock()
+	defer h.Unlock()
+	stat, ok := h.statfs[path]
+	if !ok {
+		return nil, &os.PathError{Op: "statfs", Path: path, Err: os.ErrNotExist}
+	}
+	return stat, nil
+}
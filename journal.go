@@ -0,0 +1,299 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+//Constances used by the operation journal.
+const (
+	journalDir = ".journal"
+
+	//Operations recorded in the journal.
+	journalCreate = "create"
+	journalRemove = "remove"
+
+	//Steps of the operations, recorded before they are started.
+	journalStepCreate   = "create"
+	journalStepAttach   = "attach"
+	journalStepFormat   = "format"
+	journalStepMetadata = "metadata"
+	journalStepDetach   = "detach"
+	journalStepDelete   = "delete"
+
+	//journalClockSkew is how much earlier than the start of an interrupted
+	//create the Cloud API may date the volume it created.
+	journalClockSkew = time.Minute
+)
+
+//journalEntry represents a multi-step operation in progress on a volume.
+type journalEntry struct {
+	Operation string `json:"operation"`
+	Name      string `json:"name"`
+	VolumeID  string `json:"volumeId,omitempty"`
+	//Created marks volumes created by the operation, only these are deleted
+	//when the operation is compensated.
+	Created bool `json:"created"`
+	//VolumeName is the name the volume is created with in the datacenter.
+	VolumeName string `json:"volumeName,omitempty"`
+	Step       string `json:"step"`
+	//Location is the URL of the request the step is waiting for.
+	Location  string    `json:"location,omitempty"`
+	StartedAt time.Time `json:"startedAt"`
+
+	path string
+}
+
+//beginJournal is recording the start of an operation on a volume.
+func (d *Driver) beginJournal(operation string, name string) (*journalEntry, error) {
+	dir := filepath.Join(d.metadataPath, journalDir)
+	err := os.MkdirAll(dir, metadataDirMode)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the journal directory '%v': %v", dir, err)
+	}
+
+	entry := &journalEntry{
+		Operation: operation,
+		Name:      name,
+		StartedAt: time.Now().UTC(),
+		path:      filepath.Join(dir, name),
+	}
+	err = entry.save()
+	if err != nil {
+		return nil, fmt.Errorf("failed to write the journal of volume '%v': %v", name, err)
+	}
+	return entry, nil
+}
+
+//save is writing the entry to the journal.
+func (e *journalEntry) save() error {
+	data, err := json.MarshalIndent(e, "", "\t")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(e.path, data, metadataFileMode)
+}
+
+//step is recording the step the operation is about to start.
+func (e *journalEntry) step(step string) {
+	e.Step = step
+	e.Location = ""
+	err := e.save()
+	if err != nil {
+		log.Errorf("failed to record step '%v' of volume '%v' in the journal: %v", step, e.Name, err)
+	}
+}
+
+//waiting is recording the request the current step is waiting for.
+func (e *journalEntry) waiting(location string) {
+	e.Location = location
+	err := e.save()
+	if err != nil {
+		log.Errorf("failed to record request '%v' of volume '%v' in the journal: %v", location, e.Name, err)
+	}
+}
+
+//finish is removing the entry of a finished operation from the journal.
+func (e *journalEntry) finish() {
+	err := os.Remove(e.path)
+	if err != nil && !os.IsNotExist(err) {
+		log.Errorf("failed to remove journal entry '%v': %v", e.path, err)
+	}
+}
+
+//recoverJournal is finishing operations interrupted by a crash. Creates which
+//did not write the metadata are compensated, the others are resumed. Entries
+//failing to recover are kept and retried on the next start.
+func (d *Driver) recoverJournal() {
+	dir := filepath.Join(d.metadataPath, journalDir)
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Errorf("failed to read the journal '%v': %v", dir, err)
+		}
+		return
+	}
+
+	for _, file := range files {
+		if !isMetadataFile(file) {
+			continue
+		}
+		path := filepath.Join(dir, file.Name())
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			log.Errorf("failed to read journal entry '%v': %v", path, err)
+			continue
+		}
+		entry := &journalEntry{path: path}
+		err = json.Unmarshal(data, entry)
+		if err != nil {
+			log.Errorf("failed to parse journal entry '%v': %v", path, err)
+			continue
+		}
+
+		log.Infof("Recovering interrupted %s of volume '%v' at step '%v'", entry.Operation, entry.Name, entry.Step)
+		switch entry.Operation {
+		case journalCreate:
+			err = d.recoverCreate(entry)
+		case journalRemove:
+			err = d.recoverRemove(entry)
+		default:
+			err = fmt.Errorf("unknown operation %q", entry.Operation)
+		}
+		if err != nil {
+			log.Errorf("failed to recover %s of volume '%v': %v", entry.Operation, entry.Name, err)
+			continue
+		}
+		entry.finish()
+	}
+}
+
+//recoverCreate is resuming a create which has written the metadata, and
+//compensates it otherwise.
+func (d *Driver) recoverCreate(entry *journalEntry) error {
+	d.waitForJournal(entry)
+
+	_, statErr := os.Stat(filepath.Join(d.metadataPath, entry.Name))
+	hasMetadata := statErr == nil
+	resume := entry.Step == journalStepDetach || (entry.Step == journalStepMetadata && hasMetadata)
+
+	if entry.VolumeID == "" && entry.Created {
+		//The create call might have been interrupted before returning the ID
+		volumeID, err := d.createdVolume(entry)
+		if err != nil {
+			return err
+		}
+		entry.VolumeID = volumeID
+	}
+	if entry.VolumeID == "" {
+		return nil
+	}
+
+	err := d.detachIfAttached(entry.VolumeID)
+	if err != nil || resume {
+		return err
+	}
+
+	if entry.Created {
+		log.Infof("Deleting volume %s created by the interrupted create", entry.VolumeID)
+		err = d.deleteVolume(entry.VolumeID)
+		if err != nil && !isNotFound(err) {
+			return err
+		}
+	}
+
+	err = os.Remove(filepath.Join(d.mountPath, entry.VolumeID))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if hasMetadata {
+		return os.Remove(filepath.Join(d.metadataPath, entry.Name))
+	}
+	return nil
+}
+
+//createdVolume is returning the ID of the volume an interrupted create made,
+//which has the name the create used and was created after the create started.
+//If the volume can not be told apart from others no ID is returned, so the
+//volume of someone else is never deleted.
+func (d *Driver) createdVolume(entry *journalEntry) (string, error) {
+	volumeName := entry.VolumeName
+	if volumeName == "" {
+		volumeName = taggedVolumeName(entry.Name, nil)
+	}
+	volumes, err := d.client.ListVolumes(d.datacenterID)
+	if err != nil {
+		return "", fmt.Errorf("failed to list volumes in dc '%v': %v", d.datacenterID, err)
+	}
+
+	candidates := []string{}
+	for _, v := range volumes.Items {
+		if v.Properties.Name != volumeName || v.Metadata == nil {
+			continue
+		}
+		if v.Metadata.CreatedDate.Before(entry.StartedAt.Add(-journalClockSkew)) {
+			continue
+		}
+		candidates = append(candidates, v.ID)
+	}
+	switch len(candidates) {
+	case 0:
+		log.Infof("Interrupted create of '%v' did not create a volume", entry.Name)
+		return "", nil
+	case 1:
+		log.Infof("Found volume %s created by the interrupted create of '%v'", candidates[0], entry.Name)
+		return candidates[0], nil
+	}
+	log.Warnf("Found %d volumes named %s created by the interrupted create of '%v', leaving them alone", len(candidates), volumeName, entry.Name)
+	return "", nil
+}
+
+//recoverRemove is resuming a remove.
+func (d *Driver) recoverRemove(entry *journalEntry) error {
+	d.waitForJournal(entry)
+
+	err := d.detachIfAttached(entry.VolumeID)
+	if err != nil {
+		return err
+	}
+
+	log.Infof("Deleting volume %s of the interrupted remove", entry.VolumeID)
+	err = d.deleteVolume(entry.VolumeID)
+	if err != nil && !isNotFound(err) {
+		return err
+	}
+
+	err = os.Remove(filepath.Join(d.mountPath, entry.VolumeID))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	err = os.Remove(filepath.Join(d.metadataPath, entry.Name))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+//waitForJournal is waiting for the request an interrupted step was waiting for,
+//so the volume is not busy anymore.
+func (d *Driver) waitForJournal(entry *journalEntry) {
+	if entry.Location == "" {
+		return
+	}
+
+	operation := operationCreate
+	switch entry.Step {
+	case journalStepAttach:
+		operation = operationAttach
+	case journalStepDetach:
+		operation = operationDetach
+	case journalStepDelete:
+		operation = operationDelete
+	}
+	err := d.waitTillProvisioned(operation, entry.Location)
+	if err != nil {
+		log.Warnf("Interrupted request of volume '%v' did not succeed: %v", entry.Name, err)
+	}
+}
+
+//detachIfAttached is detaching a volume if it is attached to the server.
+func (d *Driver) detachIfAttached(volumeID string) error {
+	attachedVolumes, err := d.client.ListAttachedVolumes(d.datacenterID, d.serverID)
+	if err != nil {
+		return err
+	}
+
+	for _, v := range attachedVolumes.Items {
+		if v.ID == volumeID {
+			log.Infof("Detaching volume %s left attached", volumeID)
+			return d.detachVolume(volumeID)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/profitbricks/profitbricks-sdk-go"
+)
+
+//addVolume is adding a volume created at the time to the fake datacenter.
+func addVolume(cloud *fakeCloud, name string, created time.Time) string {
+	cloud.Lock()
+	defer cloud.Unlock()
+	id := cloud.newID()
+	cloud.volumes[id] = &fakeVolume{
+		DatacenterID: testDatacenterID,
+		Volume: profitbricks.Volume{
+			ID:         id,
+			Metadata:   &profitbricks.Metadata{CreatedDate: created, State: "AVAILABLE"},
+			Properties: profitbricks.VolumeProperties{Name: name, Size: 1},
+		},
+	}
+	return id
+}
+
+//writeJournal is recording an operation interrupted at the time.
+func writeJournal(t *testing.T, dir string, entry journalEntry) {
+	entry.path = filepath.Join(dir, "metadata", journalDir, entry.Name)
+	if err := os.MkdirAll(filepath.Dir(entry.path), metadataDirMode); err != nil {
+		t.Fatal(err)
+	}
+	if err := entry.save(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRecoverCreateDeletesCreatedVolume(t *testing.T) {
+	dir, cloud := testDir(t), newFakeCloud()
+	startedAt := time.Now().UTC()
+	created := addVolume(cloud, taggedVolumeName("data", nil), startedAt.Add(time.Second))
+	writeJournal(t, dir, journalEntry{Operation: journalCreate, Name: "data", Created: true,
+		VolumeName: taggedVolumeName("data", nil), Step: journalStepCreate, StartedAt: startedAt})
+
+	d := startTestDriver(t, dir, cloud)
+	defer d.close()
+	if _, err := cloud.GetVolume(testDatacenterID, created); !isNotFound(err) {
+		t.Errorf("volume of the interrupted create was not deleted: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(d.metadataPath, journalDir, "data")); !os.IsNotExist(err) {
+		t.Errorf("journal entry was not removed: %v", err)
+	}
+}
+
+func TestRecoverCreateKeepsOtherVolumes(t *testing.T) {
+	dir, cloud := testDir(t), newFakeCloud()
+	startedAt := time.Now().UTC()
+	untagged := addVolume(cloud, "data", startedAt.Add(time.Second))
+	older := addVolume(cloud, taggedVolumeName("data", nil), startedAt.Add(-time.Hour))
+	//Entries written before the volume name was recorded
+	writeJournal(t, dir, journalEntry{Operation: journalCreate, Name: "data", Created: true,
+		Step: journalStepCreate, StartedAt: startedAt})
+
+	d := startTestDriver(t, dir, cloud)
+	defer d.close()
+	for _, volumeID := range []string{untagged, older} {
+		if _, err := cloud.GetVolume(testDatacenterID, volumeID); err != nil {
+			t.Errorf("volume %s not created by the interrupted create was deleted: %v", volumeID, err)
+		}
+	}
+}
+
+func TestRecoverCreateKeepsAmbiguousVolumes(t *testing.T) {
+	dir, cloud := testDir(t), newFakeCloud()
+	startedAt := time.Now().UTC()
+	first := addVolume(cloud, taggedVolumeName("data", nil), startedAt.Add(time.Second))
+	second := addVolume(cloud, taggedVolumeName("data", nil), startedAt.Add(2*time.Second))
+	writeJournal(t, dir, journalEntry{Operation: journalCreate, Name: "data", Created: true,
+		VolumeName: taggedVolumeName("data", nil), Step: journalStepCreate, StartedAt: startedAt})
+
+	d := startTestDriver(t, dir, cloud)
+	defer d.close()
+	for _, volumeID := range []string{first, second} {
+		if _, err := cloud.GetVolume(testDatacenterID, volumeID); err != nil {
+			t.Errorf("volume %s was deleted although it can not be told apart: %v", volumeID, err)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/profitbricks/profitbricks-sdk-go"
+)
+
+//Constances used by volume leases.
+const (
+	leaseTag             = "lease"
+	defaultLeaseDuration = 5 * time.Minute
+)
+
+//volumeLease represents the server a volume is leased to. Leases are recorded
+//in the name of the volume in the datacenter, so every host can see them.
+type volumeLease struct {
+	ServerID string
+	Expires  time.Time
+}
+
+//valid reports whether the lease has not expired yet.
+func (l *volumeLease) valid() bool {
+	return l != nil && time.Now().Before(l.Expires)
+}
+
+//taggedVolumeName is returning the name of a volume in the datacenter. The
+//name is tagged with the plugin's etag, followed by the lease if any.
+func taggedVolumeName(name string, lease *volumeLease) string {
+	if lease == nil {
+		return fmt.Sprintf("%s:%s", name, etag)
+	}
+	return fmt.Sprintf("%s:%s:%s:%s:%d", name, etag, leaseTag, lease.ServerID, lease.Expires.Unix())
+}
+
+//parseVolumeName is returning the Docker name and the lease of a volume tagged
+//by the plugin.
+func parseVolumeName(tagged string) (string, *volumeLease, bool) {
+	parts := strings.SplitN(tagged, ":"+etag, 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return "", nil, false
+	}
+	if parts[1] == "" {
+		return parts[0], nil, true
+	}
+
+	//Docker volume names never contain colons, so the remainder is the lease
+	fields := strings.Split(parts[1], ":")
+	if len(fields) != 4 || fields[0] != "" || fields[1] != leaseTag {
+		return "", nil, false
+	}
+	expires, err := strconv.ParseInt(fields[3], 10, 64)
+	if err != nil {
+		return "", nil, false
+	}
+	return parts[0], &volumeLease{ServerID: fields[2], Expires: time.Unix(expires, 0)}, true
+}
+
+//leaseStatus is returning the lease of a volume for the status reported to Docker.
+func leaseStatus(v profitbricks.Volume) map[string]interface{} {
+	_, lease, _ := parseVolumeName(v.Properties.Name)
+	if !lease.valid() {
+		return nil
+	}
+	return map[string]interface{}{
+		"LeasedTo":     lease.ServerID,
+		"LeaseExpires": lease.Expires.UTC().Format(time.RFC3339),
+	}
+}
+
+//currentLease is returning the lease of a volume as recorded in the datacenter.
+func (d *Driver) currentLease(volumeID string) (*volumeLease, error) {
+	vol, err := d.client.GetVolume(d.datacenterID, volumeID)
+	if err != nil {
+		return nil, err
+	}
+	_, lease, _ := parseVolumeName(vol.Properties.Name)
+	return lease, nil
+}
+
+//writeLease is recording the lease of a volume in its name and waits till it is done.
+func (d *Driver) writeLease(name string, volumeID string, lease *volumeLease) error {
+	properties := profitbricks.VolumeProperties{Name: taggedVolumeName(name, lease)}
+	updateResp, err := d.client.UpdateVolume(d.datacenterID, volumeID, properties)
+	if err != nil {
+		return err
+	}
+	d.invalidateRemoteVolumes()
+
+	//Lease updates are polled like attachments they precede
+	return d.waitTillProvisioned(operationAttach, updateResp.Headers.Get("Location"))
+}
+
+//acquireLease is leasing a volume to this server, or renewing its lease. It
+//fails when another server holds a lease which has not expired.
+func (d *Driver) acquireLease(name string, volumeID string) error {
+	if d.leaseDuration == 0 {
+		return nil
+	}
+
+	lease, err := d.currentLease(volumeID)
+	if err != nil {
+		return fmt.Errorf("failed to get the lease of volume %q: %v", name, err)
+	}
+	if lease.valid() && lease.ServerID != d.serverID {
+		return fmt.Errorf("Volume %q is leased to server '%v' until %v", name, lease.ServerID, lease.Expires.UTC().Format(time.RFC3339))
+	}
+	if lease != nil && lease.ServerID != d.serverID {
+		log.Warnf("Lease of volume %s held by server %s expired at %v, taking it over", name, lease.ServerID, lease.Expires.UTC().Format(time.RFC3339))
+	}
+
+	err = d.writeLease(name, volumeID, &volumeLease{ServerID: d.serverID, Expires: time.Now().Add(d.leaseDuration)})
+	if err != nil {
+		return fmt.Errorf("failed to lease volume %q: %v", name, err)
+	}
+
+	//The Cloud API has no compare-and-swap, so a concurrent writer wins silently
+	lease, err = d.currentLease(volumeID)
+	if err != nil {
+		return fmt.Errorf("failed to get the lease of volume %q: %v", name, err)
+	}
+	if lease == nil || lease.ServerID != d.serverID {
+		return fmt.Errorf("Volume %q was leased by another server concurrently", name)
+	}
+	return nil
+}
+
+//releaseLease is removing the lease of a volume held by this server. Failures
+//are logged only, the lease expires anyway.
+func (d *Driver) releaseLease(name string, volumeID string) {
+	if d.leaseDuration == 0 {
+		return
+	}
+
+	lease, err := d.currentLease(volumeID)
+	if err != nil {
+		log.Errorf("failed to get the lease of volume '%v': %v", name, err)
+		return
+	}
+	if lease == nil || lease.ServerID != d.serverID {
+		return
+	}
+
+	err = d.writeLease(name, volumeID, nil)
+	if err != nil {
+		log.Errorf("failed to release the lease of volume '%v': %v", name, err)
+	}
+}
+
+//renewLeases is renewing the leases of mounted volumes every third of the
+//lease duration till the driver is shut down.
+func (d *Driver) renewLeases() {
+	ticker := time.NewTicker(d.leaseDuration / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-d.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		d.RLock()
+		names := make([]string, 0, len(d.volumes))
+		for name := range d.volumes {
+			names = append(names, name)
+		}
+		d.RUnlock()
+
+		for _, name := range names {
+			if d.ctx.Err() != nil {
+				return
+			}
+			d.renewLease(name)
+		}
+	}
+}
+
+//renewLease is renewing the lease of a volume if it is mounted. The volume is
+//not locked while the lease is written, which can take till the attach
+//timeout, so mounts and unmounts of the volume are not held up.
+func (d *Driver) renewLease(name string) {
+	unlock := d.lockVolume(name)
+	vol, ok := d.lookupVolume(name)
+	if !ok || vol.Missing || len(vol.Mounts) == 0 {
+		unlock()
+		return
+	}
+	volumeID := vol.VolumeID
+	unlock()
+
+	log.Debugf("Renewing the lease of volume %s", name)
+	err := d.acquireLease(name, volumeID)
+	if err != nil {
+		log.Errorf("failed to renew the lease of mounted volume '%v': %v", name, err)
+		return
+	}
+
+	//An unmount might have released the lease before it was renewed
+	unlock = d.lockVolume(name)
+	defer unlock()
+	vol, ok = d.lookupVolume(name)
+	if !ok || len(vol.Mounts) == 0 {
+		d.releaseLease(name, volumeID)
+	}
+}
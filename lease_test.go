@@ -0,0 +1,113 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/go-plugins-helpers/volume"
+	"github.com/profitbricks/profitbricks-sdk-go"
+)
+
+//stallingCloud is a CloudAPI holding up the next call of a method till it is released.
+type stallingCloud struct {
+	*fakeCloud
+	mutex   sync.Mutex
+	method  string
+	stalled chan struct{}
+	release chan struct{}
+}
+
+//stall is making the next call of the method wait for the release.
+func (c *stallingCloud) stall(method string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.method = method
+	c.stalled = make(chan struct{})
+	c.release = make(chan struct{})
+}
+
+//wait is waiting for the release if the method is stalled.
+func (c *stallingCloud) wait(method string) {
+	c.mutex.Lock()
+	if c.method != method {
+		c.mutex.Unlock()
+		return
+	}
+	stalled, release := c.stalled, c.release
+	c.method, c.stalled, c.release = "", nil, nil
+	c.mutex.Unlock()
+
+	close(stalled)
+	<-release
+}
+
+//UpdateVolume is updating volume properties, after the release if stalled.
+func (c *stallingCloud) UpdateVolume(dcid string, volid string, request profitbricks.VolumeProperties) (*profitbricks.Volume, error) {
+	c.wait("UpdateVolume")
+	return c.fakeCloud.UpdateVolume(dcid, volid, request)
+}
+
+//AttachVolume is attaching a volume to a server, after the release if stalled.
+func (c *stallingCloud) AttachVolume(dcid string, srvid string, volid string) (*profitbricks.Volume, error) {
+	c.wait("AttachVolume")
+	return c.fakeCloud.AttachVolume(dcid, srvid, volid)
+}
+
+//lease is returning the lease of a volume recorded in the fake datacenter.
+func (d *testDriver) lease(volumeID string) *volumeLease {
+	d.cloud.Lock()
+	defer d.cloud.Unlock()
+	_, lease, _ := parseVolumeName(d.cloud.volumes[volumeID].Volume.Properties.Name)
+	return lease
+}
+
+func TestRenewLeaseDoesNotBlockUnmount(t *testing.T) {
+	cloud := newFakeCloud()
+	stalling := &stallingCloud{fakeCloud: cloud}
+	d := startLeasingDriver(t, testDir(t), cloud, stalling, time.Hour)
+	defer d.close()
+
+	if resp := d.Create(volume.Request{Name: "vol"}); resp.Err != "" {
+		t.Fatal(resp.Err)
+	}
+	volumeID := d.volumeID(t, "vol")
+	d.formatted(volumeID)
+	if resp := d.Mount(volume.MountRequest{Name: "vol", ID: "container"}); resp.Err != "" {
+		t.Fatal(resp.Err)
+	}
+	if lease := d.lease(volumeID); lease == nil || lease.ServerID != testServerID {
+		t.Fatalf("mounted volume has lease %v", lease)
+	}
+
+	stalling.stall("UpdateVolume")
+	stalled := stalling.stalled
+	release := stalling.release
+	renewed := make(chan struct{})
+	go func() {
+		defer close(renewed)
+		d.renewLease("vol")
+	}()
+	<-stalled
+
+	unmounted := make(chan volume.Response)
+	go func() {
+		unmounted <- d.Unmount(volume.UnmountRequest{Name: "vol", ID: "container"})
+	}()
+	select {
+	case resp := <-unmounted:
+		if resp.Err != "" {
+			t.Fatal(resp.Err)
+		}
+	case <-time.After(5 * time.Second):
+		close(release)
+		t.Fatal("unmount waited for the lease renewal")
+	}
+
+	//The renewal finishing after the unmount must not leave the volume leased
+	close(release)
+	<-renewed
+	if lease := d.lease(volumeID); lease != nil {
+		t.Errorf("unmounted volume is still leased %v", lease)
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"sync"
+)
+
+//volumeLocks is serializing operations on the same volume, while operations
+//on different volumes run concurrently.
+type volumeLocks struct {
+	sync.Mutex
+	locks map[string]*volumeLock
+}
+
+//volumeLock represents a lock of a single volume.
+type volumeLock struct {
+	sync.Mutex
+	//users is the number of operations holding or waiting for the lock.
+	users int
+}
+
+//newVolumeLocks is a constructor of the volume locks.
+func newVolumeLocks() *volumeLocks {
+	return &volumeLocks{locks: make(map[string]*volumeLock)}
+}
+
+//lock is locking a volume and returns the function unlocking it. Locks are
+//dropped once no operation uses them anymore.
+func (l *volumeLocks) lock(name string) func() {
+	l.Lock()
+	vl, ok := l.locks[name]
+	if !ok {
+		vl = &volumeLock{}
+		l.locks[name] = vl
+	}
+	vl.users++
+	l.Unlock()
+
+	vl.Lock()
+	return func() {
+		vl.Unlock()
+
+		l.Lock()
+		defer l.Unlock()
+		vl.users--
+		if vl.users == 0 {
+			delete(l.locks, name)
+		}
+	}
+}
@@ -3,6 +3,10 @@ package main
 import (
 	"fmt"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/docker/go-plugins-helpers/volume"
@@ -21,8 +25,22 @@ type CommandLineArgs struct {
 	datacenterID         *string
 	size                 *int
 	diskType             *string
+	filesystem           *string
+	mkfsOptions          *string
+	mountOptions         *string
 	credentialFilePath   *string
 	logLevel             *string
+	provider             *string
+	loopPath             *string
+	serverID             *string
+	missingVolumePolicy  *string
+	takeoverPolicy       *string
+	leaseDuration        *time.Duration
+	polling              map[string]*pollConfig
+	retry                *retryConfig
+	volumeCacheTTL       *time.Duration
+	usage                *usageConfig
+	socket               *string
 }
 
 //Constances used at application level.
@@ -32,6 +50,11 @@ const (
 	defaultBaseMountPath    = "/var/run/docker/volumedriver/profitbricks"
 	defaultUnixSocketGroup  = "docker"
 	driverVersion           = "1.0.0"
+	defaultLoopPath         = "/var/lib/docker-volume-profitbricks/loop"
+	defaultLoopDatacenterID = "local"
+	defaultLoopServerID     = "local"
+	providerProfitBricks    = "profitbricks"
+	providerLoop            = "loop"
 )
 
 func main() {
@@ -46,18 +69,35 @@ func main() {
 	}
 	log.SetLevel(logLevel)
 
-	log.Infof("initialization parameters: profitbricks-endpoint=%s profitbricks-username=%s credential-file-path=%s profitbricks-datacenter-id=%s profitbricks-volume-size=%d profitbricks-disk-type=%s metadata-path=%s mount-path=%s unix-socket-group=%s version=%s log-level=%s",
+	log.Infof("initialization parameters: profitbricks-endpoint=%s profitbricks-username=%s credential-file-path=%s profitbricks-datacenter-id=%s profitbricks-volume-size=%d profitbricks-disk-type=%s metadata-path=%s mount-path=%s unix-socket-group=%s version=%t log-level=%s",
 		*args.profitbricksEndpoint, *args.profitbricksUsername,
 		*args.credentialFilePath, *args.datacenterID, *args.size,
 		*args.diskType, *args.metadataPath, *args.mountPath,
 		*args.unixSocketGroup, *args.version, *args.logLevel)
 
-	driver, err := ProfitBricksDriver(mountUtil, *args)
+	var driver *Driver
+	if *args.provider == providerLoop {
+		log.Infof("Using loop devices under %s instead of the Cloud API", *args.loopPath)
+		driver, err = LoopDriver(mountUtil, *args)
+	} else {
+		driver, err = ProfitBricksDriver(mountUtil, *args)
+	}
 	if err != nil {
 		log.Fatalf("failed to create the driver: %v", err)
 		os.Exit(1)
 	}
 	handler := volume.NewHandler(driver)
+	handler.HandleFunc(adminResizePath, driver.handleResize)
+
+	//Cancel requests being polled on shutdown
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		log.Infof("Received %v, shutting down", sig)
+		driver.Shutdown()
+		os.Exit(0)
+	}()
 
 	//Start listening in a unix socket
 	log.Info("Listening on", *args.unixSocketGroup)
@@ -82,14 +122,52 @@ func parseCommandLineArgs(mountUtil *Utilities) *CommandLineArgs {
 	args.datacenterID = flag.StringP("profitbricks-datacenter-id", "d", os.Getenv("PROFITBRICKS_DATACENTER_ID"), "ProfitBricks Virtual Data Center ID")
 	args.size = flag.IntP("profitbricks-volume-size", "s", 50, "ProfitBricks Volume size")
 	args.diskType = flag.StringP("profitbricks-disk-type", "t", "HDD", "ProfitBricks Volume type")
+	args.serverID = flag.String("server-id", "", "ProfitBricks server ID, read from the product UUID by default")
+	args.volumeCacheTTL = flag.Duration("volume-cache-ttl", defaultVolumeCacheTTL, "how long volumes listed in the datacenter are cached")
 
 	//Mount parameters
 	args.metadataPath = flag.String("metadata-path", defaultBaseMetadataPath, "the path under which to store volume metadata")
 	args.mountPath = flag.StringP("mount-path", "m", defaultBaseMountPath, "the path under which to create the volume mount folders")
 	args.unixSocketGroup = flag.StringP("unix-socket-group", "g", defaultUnixSocketGroup, "the group to assign to the Unix socket file")
+	args.filesystem = flag.String("fs-type", defaultFilesystem, fmt.Sprintf("the filesystem new volumes are formatted with: %s", strings.Join(supportedFilesystems(), ", ")))
+	args.mkfsOptions = flag.String("mkfs-options", "", "the mkfs options of new volumes of the --fs-type filesystem requesting none, separated by spaces")
+	args.mountOptions = flag.String("mount-options", "", "the mount options of volumes of the --fs-type filesystem requesting none, separated by commas")
+	args.missingVolumePolicy = flag.String("missing-volume-policy", missingVolumeKeep, "what to do on startup with volumes deleted from the datacenter: \"keep\", \"quarantine\" or \"forget\"")
+	args.leaseDuration = flag.Duration("lease-duration", defaultLeaseDuration, "how long mounted volumes are leased to this server, renewed every third of it, 0 disables leases")
+	args.takeoverPolicy = flag.String("takeover-policy", takeoverStopped, "when to detach volumes attached to another server on mount: \"never\", \"stopped\" if that server is stopped or gone, or \"always\"")
+
+	//Provider parameters
+	args.provider = flag.String("provider", providerProfitBricks, "the volume provider, either \"profitbricks\" or \"loop\" for local loop devices")
+	args.loopPath = flag.String("loop-path", defaultLoopPath, "the path under which the loop provider stores volume images")
+
+	//Polling parameters
+	args.polling = defaultPollConfigs()
+	for _, operation := range []string{operationCreate, operationAttach, operationDetach, operationDelete, operationSnapshot, operationResize} {
+		config := args.polling[operation]
+		flag.DurationVar(&config.initialInterval, operation+"-poll-interval", config.initialInterval, fmt.Sprintf("the initial interval of polling %s requests", operation))
+		flag.DurationVar(&config.maxInterval, operation+"-poll-max-interval", config.maxInterval, fmt.Sprintf("the maximum interval of polling %s requests", operation))
+		flag.DurationVar(&config.timeout, operation+"-timeout", config.timeout, fmt.Sprintf("the time to wait for %s requests", operation))
+	}
+	device := args.polling[operationDevice]
+	flag.DurationVar(&device.initialInterval, "device-poll-interval", device.initialInterval, "the initial interval of polling for the device of an attached volume")
+	flag.DurationVar(&device.maxInterval, "device-poll-max-interval", device.maxInterval, "the maximum interval of polling for the device of an attached volume")
+	flag.DurationVar(&device.timeout, "device-timeout", device.timeout, "the time to wait for the device of an attached volume to appear")
+
+	//Retry parameters
+	args.retry = &retryConfig{}
+	flag.IntVar(&args.retry.retries, "api-retries", 5, "the number of retries of Cloud API calls failing for transient reasons, 0 disables retries")
+	flag.DurationVar(&args.retry.initialDelay, "api-retry-delay", time.Second, "the initial delay between retries of Cloud API calls")
+	flag.DurationVar(&args.retry.maxDelay, "api-retry-max-delay", 30*time.Second, "the maximum delay between retries of Cloud API calls")
+
+	//Usage parameters
+	args.usage = &usageConfig{}
+	flag.DurationVar(&args.usage.interval, "usage-interval", defaultUsageInterval, "the interval of logging the filesystem usage of mounted volumes, 0 disables it")
+	flag.Float64Var(&args.usage.warningPercent, "usage-warning-percent", defaultUsageWarningPercent, "the filesystem usage in percent flagged as warning")
+	flag.Float64Var(&args.usage.criticalPercent, "usage-critical-percent", defaultUsageCriticalPercent, "the filesystem usage in percent flagged as critical")
 
 	//Other parameters
 	args.version = flag.BoolP("version", "v", false, "outputs the driver version and exits")
+	args.socket = flag.String("socket", "", "the plugin socket admin commands connect to, found in "+pluginSocketDir+" by default")
 	args.logLevel = flag.StringP("log-level", "l", "error", "log level")
 	flag.Parse()
 
@@ -98,6 +176,11 @@ func parseCommandLineArgs(mountUtil *Utilities) *CommandLineArgs {
 		os.Exit(0)
 	}
 
+	//Admin commands talk to the running plugin
+	if flag.Arg(0) == "resize" {
+		os.Exit(runResize(flag.Args()[1:], *args.socket))
+	}
+
 	//Try to get values from the environment variables
 	if os.Getenv("PROFITBRICKS_ENDPOINT") != "" {
 		*args.profitbricksEndpoint = os.Getenv("PROFITBRICKS_ENDPOINT")
@@ -127,6 +210,71 @@ func parseCommandLineArgs(mountUtil *Utilities) *CommandLineArgs {
 	}
 
 	//Final validation
+	switch *args.missingVolumePolicy {
+	case missingVolumeKeep, missingVolumeQuarantine, missingVolumeForget:
+	default:
+		fmt.Println(fmt.Errorf("Unknown missing volume policy %q, use %q, %q or %q", *args.missingVolumePolicy, missingVolumeKeep, missingVolumeQuarantine, missingVolumeForget))
+		os.Exit(1)
+	}
+
+	switch *args.takeoverPolicy {
+	case takeoverNever, takeoverStopped, takeoverAlways:
+	default:
+		fmt.Println(fmt.Errorf("Unknown takeover policy %q, use %q, %q or %q", *args.takeoverPolicy, takeoverNever, takeoverStopped, takeoverAlways))
+		os.Exit(1)
+	}
+
+	filesystem, err := newFilesystem(*args.filesystem, mountUtil.host)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if _, err := parseMkfsOptions(filesystem, *args.mkfsOptions); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if _, err := parseMountOptions(filesystem, *args.mountOptions); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	if *args.leaseDuration < 0 {
+		fmt.Println(fmt.Errorf("The lease duration must not be negative"))
+		os.Exit(1)
+	}
+
+	if args.retry.retries < 0 || args.retry.initialDelay <= 0 || args.retry.initialDelay > args.retry.maxDelay {
+		fmt.Println(fmt.Errorf("Retries must not be negative and the retry delay has to be positive and not greater than the maximum delay"))
+		os.Exit(1)
+	}
+
+	if err := args.usage.validate(); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	for operation, config := range args.polling {
+		if err := config.validate(operation); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+
+	if *args.provider == providerLoop {
+		if *args.datacenterID == "" {
+			*args.datacenterID = defaultLoopDatacenterID
+		}
+		if _, err := mountUtil.GetServerID(); *args.serverID == "" && err != nil {
+			*args.serverID = defaultLoopServerID
+		}
+		return args
+	}
+
+	if *args.provider != providerProfitBricks {
+		fmt.Println(fmt.Errorf("Unknown provider %q, use %q or %q", *args.provider, providerProfitBricks, providerLoop))
+		os.Exit(1)
+	}
+
 	if *args.profitbricksUsername == "" {
 		fmt.Println(fmt.Errorf("Username should be provided either using %q or using the environment variable %q", "--profitbricks-username", "PROFITBRICKS_USERNAME"))
 		os.Exit(1)
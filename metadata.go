@@ -0,0 +1,237 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+//Constances used for the metadata files.
+const (
+	//metadataVersion is the current schema version of the metadata files.
+	//Version 0 are the empty files and the bare mount references written by older versions.
+	metadataVersion   = 1
+	defaultFilesystem = "ext4"
+	metadataTmpSuffix = ".tmp"
+	quarantineDir     = ".quarantine"
+
+	//Policies for volumes which no longer exist in the datacenter.
+	missingVolumeKeep       = "keep"
+	missingVolumeQuarantine = "quarantine"
+	missingVolumeForget     = "forget"
+)
+
+//volumeState represents a volume state in the metadata.
+type volumeState struct {
+	Version      int               `json:"version"`
+	VolumeID     string            `json:"volumeId"`
+	DatacenterID string            `json:"datacenterId"`
+	Size         int               `json:"size"`
+	Type         string            `json:"type"`
+	Filesystem   string            `json:"filesystem"`
+	MkfsOptions  []string          `json:"mkfsOptions,omitempty"`
+	MountOptions []string          `json:"mountOptions,omitempty"`
+	Options      map[string]string `json:"options,omitempty"`
+	CreatedAt    time.Time         `json:"createdAt"`
+	MountPoint   string            `json:"mountPoint"`
+	DeviceName   string            `json:"deviceName,omitempty"`
+	//Mounts holds IDs of the callers the volume is currently mounted for.
+	Mounts map[string]bool `json:"mounts"`
+	//Missing marks volumes no longer existing in the datacenter.
+	Missing bool `json:"-"`
+	//GrowPending marks volumes resized in the datacenter whose filesystem has not been grown yet.
+	GrowPending bool `json:"growPending,omitempty"`
+	//LastGrow is the last time the filesystem was grown.
+	LastGrow *growEvent `json:"lastGrow,omitempty"`
+}
+
+//copy is returning a copy of the state with its own mount references.
+func (s *volumeState) copy() *volumeState {
+	c := *s
+	c.Mounts = make(map[string]bool, len(s.Mounts))
+	for id := range s.Mounts {
+		c.Mounts[id] = true
+	}
+	return &c
+}
+
+//missingVolumeError is returned for volumes no longer existing in the datacenter.
+type missingVolumeError struct {
+	name     string
+	volumeID string
+}
+
+//Error is returning a message of the error.
+func (e missingVolumeError) Error() string {
+	if e.volumeID == "" {
+		return fmt.Sprintf("Volume '%v' not found", e.name)
+	}
+	return fmt.Sprintf("Volume '%v' (%v) not found", e.name, e.volumeID)
+}
+
+//isMetadataFile reports whether a directory entry is a volume metadata file.
+//Hidden entries hold temporary files and other plugin data.
+func isMetadataFile(info os.FileInfo) bool {
+	return info.Mode().IsRegular() && !strings.HasPrefix(info.Name(), ".")
+}
+
+//writeMetadata is persisting a volume state in its metadata file.
+func (d *Driver) writeMetadata(name string, state *volumeState) error {
+	jsn, err := json.MarshalIndent(state, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	metadataFilePath := filepath.Join(d.metadataPath, name)
+	log.Infof("Metadata file path %s", metadataFilePath)
+	return writeFileAtomic(metadataFilePath, jsn, metadataFileMode)
+}
+
+//readMetadata is loading a volume state from its metadata file.
+func (d *Driver) readMetadata(name string) (*volumeState, error) {
+	jsn, err := ioutil.ReadFile(filepath.Join(d.metadataPath, name))
+	if err != nil {
+		return nil, err
+	}
+
+	state := &volumeState{}
+	if len(jsn) > 0 {
+		err = json.Unmarshal(jsn, state)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse metadata of volume '%v': %v", name, err)
+		}
+	}
+	if state.Mounts == nil {
+		state.Mounts = make(map[string]bool)
+	}
+	return state, nil
+}
+
+//migrateMetadata is upgrading a metadata file written by an older version,
+//looking up missing details of the volume in the Cloud API.
+func (d *Driver) migrateMetadata(name string, state *volumeState) error {
+	log.Infof("Migrating metadata of volume '%v' from version %d to %d", name, state.Version, metadataVersion)
+
+	if state.VolumeID == "" {
+		//Only a listing without the volume proves it missing, other errors
+		//leave the metadata for the next start
+		volumeID, err := d.findVolumeByName(name)
+		if isNotFound(err) {
+			return missingVolumeError{name: name}
+		}
+		if err != nil {
+			return err
+		}
+		state.VolumeID = volumeID
+	}
+
+	vol, err := d.client.GetVolume(d.datacenterID, state.VolumeID)
+	if isNotFound(err) {
+		return missingVolumeError{name: name, volumeID: state.VolumeID}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get volume '%v': %v", name, err)
+	}
+
+	state.Version = metadataVersion
+	state.DatacenterID = d.datacenterID
+	state.Size = vol.Properties.Size
+	state.Type = vol.Properties.Type
+	state.Filesystem = defaultFilesystem
+	state.MountPoint = filepath.Join(d.mountPath, state.VolumeID)
+	if vol.Metadata != nil {
+		state.CreatedAt = vol.Metadata.CreatedDate
+	}
+	if state.CreatedAt.IsZero() {
+		if info, err := os.Stat(filepath.Join(d.metadataPath, name)); err == nil {
+			state.CreatedAt = info.ModTime().UTC()
+		}
+	}
+
+	return d.writeMetadata(name, state)
+}
+
+//handleMissingVolume is applying the missing volume policy to a volume which
+//no longer exists in the datacenter.
+func (d *Driver) handleMissingVolume(name string, state *volumeState, reason error) {
+	metadataFilePath := filepath.Join(d.metadataPath, name)
+
+	switch d.missingVolumePolicy {
+	case missingVolumeForget:
+		log.Warnf("%v, forgetting it and removing metadata file '%v'", reason, metadataFilePath)
+		err := os.Remove(metadataFilePath)
+		if err != nil {
+			log.Errorf("failed to remove metadata file '%v': %v", metadataFilePath, err)
+		}
+	case missingVolumeQuarantine:
+		quarantinePath := filepath.Join(d.metadataPath, quarantineDir)
+		log.Warnf("%v, moving metadata file '%v' to '%v'", reason, metadataFilePath, quarantinePath)
+		err := os.MkdirAll(quarantinePath, metadataDirMode)
+		if err == nil {
+			err = os.Rename(metadataFilePath, filepath.Join(quarantinePath, name))
+		}
+		if err != nil {
+			log.Errorf("failed to quarantine metadata file '%v': %v", metadataFilePath, err)
+		}
+	default:
+		log.Warnf("%v, keeping it as missing", reason)
+		if state == nil {
+			state = &volumeState{Mounts: make(map[string]bool)}
+		}
+		state.Missing = true
+		d.volumes[name] = state
+	}
+}
+
+//removeStaleTmpFiles is removing temporary files left by interrupted writes.
+func (d *Driver) removeStaleTmpFiles() {
+	tmpFiles, _ := filepath.Glob(filepath.Join(d.metadataPath, ".*"+metadataTmpSuffix+"*"))
+	journalTmpFiles, _ := filepath.Glob(filepath.Join(d.metadataPath, journalDir, ".*"+metadataTmpSuffix+"*"))
+	for _, tmpFile := range append(tmpFiles, journalTmpFiles...) {
+		log.Infof("Removing stale temporary metadata file '%v'", tmpFile)
+		os.Remove(tmpFile)
+	}
+}
+
+//writeFileAtomic is replacing a file with a fully written and synced temporary
+//file, so a crash leaves either the old or the new content.
+func writeFileAtomic(path string, data []byte, mode os.FileMode) error {
+	dir := filepath.Dir(path)
+	tmpFile, err := ioutil.TempFile(dir, "."+filepath.Base(path)+metadataTmpSuffix)
+	if err != nil {
+		return err
+	}
+	tmpPath := tmpFile.Name()
+
+	_, err = tmpFile.Write(data)
+	if err == nil {
+		err = tmpFile.Chmod(mode)
+	}
+	if err == nil {
+		err = tmpFile.Sync()
+	}
+	if cerr := tmpFile.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, path)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	//Persist the rename itself
+	dirFile, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer dirFile.Close()
+	return dirFile.Sync()
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/docker/go-plugins-helpers/volume"
+	"github.com/profitbricks/profitbricks-sdk-go"
+)
+
+//listFailingCloud is failing every listing of volumes with its error.
+type listFailingCloud struct {
+	*fakeCloud
+	err error
+}
+
+//ListVolumes is listing volumes of the datacenter.
+func (c *listFailingCloud) ListVolumes(dcid string) (*profitbricks.Volumes, error) {
+	return nil, c.err
+}
+
+//writeMetadataFile is writing the content as metadata file of a volume.
+func writeMetadataFile(t *testing.T, dir string, name string, content string) string {
+	metadataFilePath := filepath.Join(dir, "metadata", name)
+	if err := os.MkdirAll(filepath.Dir(metadataFilePath), metadataDirMode); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(metadataFilePath, []byte(content), metadataFileMode); err != nil {
+		t.Fatal(err)
+	}
+	return metadataFilePath
+}
+
+//startPolicyDriver is starting a test driver with the missing volume policy,
+//which lists volumes through the client.
+func startPolicyDriver(t *testing.T, dir string, cloud *fakeCloud, client CloudAPI, policy string) *testDriver {
+	return startConfiguredDriver(t, dir, cloud, client, newTestHost(), func(args *CommandLineArgs) {
+		args.missingVolumePolicy = &policy
+	})
+}
+
+func TestMigrateKeepsMetadataOnListingErrors(t *testing.T) {
+	for _, policy := range []string{missingVolumeKeep, missingVolumeQuarantine, missingVolumeForget} {
+		for _, listErr := range []error{
+			errors.New("dial tcp: lookup api.profitbricks.com: no such host"),
+			fakeAPIError{status: http.StatusUnauthorized, message: "unauthorized"},
+			fakeAPIError{status: http.StatusInternalServerError, message: "internal error"},
+		} {
+			dir, cloud := testDir(t), newFakeCloud()
+			metadataFilePath := writeMetadataFile(t, dir, "data", "")
+
+			d := startPolicyDriver(t, dir, cloud, &listFailingCloud{fakeCloud: cloud, err: listErr}, policy)
+			if _, err := os.Stat(metadataFilePath); err != nil {
+				t.Errorf("%s policy removed the metadata on %q: %v", policy, listErr, err)
+			}
+			if _, err := os.Stat(filepath.Join(d.metadataPath, quarantineDir, "data")); !os.IsNotExist(err) {
+				t.Errorf("%s policy quarantined the metadata on %q: %v", policy, listErr, err)
+			}
+			if state, ok := d.lookupVolume("data"); ok && state.Missing {
+				t.Errorf("%s policy reported the volume missing on %q", policy, listErr)
+			}
+			d.close()
+		}
+	}
+}
+
+func TestMigrateAppliesPolicyToMissingVolumes(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		client func(cloud *fakeCloud) CloudAPI
+	}{
+		{"absent", func(cloud *fakeCloud) CloudAPI { return cloud }},
+		{"not found", func(cloud *fakeCloud) CloudAPI {
+			return &listFailingCloud{fakeCloud: cloud, err: fakeAPIError{status: http.StatusNotFound, message: "not found"}}
+		}},
+	} {
+		for _, policy := range []string{missingVolumeKeep, missingVolumeQuarantine, missingVolumeForget} {
+			dir, cloud := testDir(t), newFakeCloud()
+			addVolume(cloud, "other", time.Now())
+			metadataFilePath := writeMetadataFile(t, dir, "data", "")
+
+			d := startPolicyDriver(t, dir, cloud, test.client(cloud), policy)
+			_, statErr := os.Stat(metadataFilePath)
+			_, quarantineErr := os.Stat(filepath.Join(d.metadataPath, quarantineDir, "data"))
+			state, registered := d.lookupVolume("data")
+			switch policy {
+			case missingVolumeKeep:
+				if statErr != nil || !registered || !state.Missing {
+					t.Errorf("%s volume was not kept as missing: %v", test.name, statErr)
+				}
+			case missingVolumeQuarantine:
+				if !os.IsNotExist(statErr) || quarantineErr != nil || registered {
+					t.Errorf("%s volume was not quarantined: %v", test.name, quarantineErr)
+				}
+			case missingVolumeForget:
+				if !os.IsNotExist(statErr) || !os.IsNotExist(quarantineErr) || registered {
+					t.Errorf("%s volume was not forgotten: %v", test.name, statErr)
+				}
+			}
+			d.close()
+		}
+	}
+}
+
+//seedVolume is adding a volume to the fake datacenter, attached to the server
+//if it is set, and writes its metadata with the mount references.
+func seedVolume(t *testing.T, dir string, cloud *fakeCloud, name string, serverID string, mounts ...string) *volumeState {
+	volumeID := addVolume(cloud, taggedVolumeName(name, nil), time.Now())
+	cloud.Lock()
+	cloud.volumes[volumeID].ServerID = serverID
+	cloud.Unlock()
+
+	state := &volumeState{
+		Version:      metadataVersion,
+		VolumeID:     volumeID,
+		DatacenterID: testDatacenterID,
+		Size:         1,
+		Filesystem:   filesystemExt4,
+		MountPoint:   filepath.Join(dir, "mnt", volumeID),
+		Mounts:       make(map[string]bool),
+	}
+	for _, id := range mounts {
+		state.Mounts[id] = true
+	}
+	jsn, err := json.Marshal(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeMetadataFile(t, dir, name, string(jsn))
+	return state
+}
+
+func TestRestartRestoresMounts(t *testing.T) {
+	dir, cloud := testDir(t), newFakeCloud()
+	inUse := seedVolume(t, dir, cloud, "inuse", testServerID, "first", "second")
+	stale := seedVolume(t, dir, cloud, "stale", testServerID, "gone")
+	unused := seedVolume(t, dir, cloud, "unused", testServerID)
+	idle := seedVolume(t, dir, cloud, "idle", "")
+	deleted := seedVolume(t, dir, cloud, "deleted", "", "gone")
+	cloud.Lock()
+	delete(cloud.volumes, deleted.VolumeID)
+	cloud.Unlock()
+
+	host := newTestHost()
+	host.setFile(mountInfoPath, "36 35 98:0 / "+inUse.MountPoint+" rw,noatime master:1 - ext4 /dev/vdb rw\n"+
+		"37 35 98:16 / "+unused.MountPoint+" rw,noatime master:1 - ext4 /dev/vdc rw\n")
+	d := startConfiguredDriver(t, dir, cloud, cloud, host, func(args *CommandLineArgs) {})
+	defer d.close()
+
+	//Still mounted and referenced
+	vol, _ := d.lookupVolume("inuse")
+	if !reflect.DeepEqual(vol.Mounts, inUse.Mounts) || vol.DeviceName != "/dev/vdb" {
+		t.Errorf("mounted volume has the references %v and device %q", vol.Mounts, vol.DeviceName)
+	}
+	if d.executed("umount "+inUse.MountPoint) || d.attachedTo(inUse.VolumeID) != testServerID {
+		t.Error("mounted volume in use was unmounted or detached")
+	}
+
+	//Referenced, but no longer mounted
+	vol, _ = d.lookupVolume("stale")
+	if len(vol.Mounts) != 0 || d.attachedTo(stale.VolumeID) != "" {
+		t.Errorf("volume no longer mounted kept the references %v or its attachment", vol.Mounts)
+	}
+	if state, err := d.readMetadata("stale"); err != nil || len(state.Mounts) != 0 {
+		t.Errorf("metadata of the volume no longer mounted holds %v: %v", state.Mounts, err)
+	}
+
+	//Mounted without references
+	if !d.executed("umount "+unused.MountPoint) || d.attachedTo(unused.VolumeID) != "" {
+		t.Errorf("mounted volume without references was not unmounted and detached, executed %q", d.host.executed())
+	}
+
+	//Neither mounted nor attached
+	if vol, ok := d.lookupVolume("idle"); !ok || len(vol.Mounts) != 0 || d.attachedTo(idle.VolumeID) != "" {
+		t.Errorf("idle volume was not restored as it was: %v", vol)
+	}
+	if vol, ok := d.lookupVolume("deleted"); !ok || !vol.Missing {
+		t.Error("volume deleted in the datacenter is not kept as missing")
+	}
+
+	//The restored references are released by the callers
+	if resp := d.Unmount(volume.UnmountRequest{Name: "inuse", ID: "first"}); resp.Err != "" {
+		t.Fatal(resp.Err)
+	}
+	if d.attachedTo(inUse.VolumeID) != testServerID {
+		t.Error("volume was detached while the second caller still has it mounted")
+	}
+	if resp := d.Unmount(volume.UnmountRequest{Name: "inuse", ID: "second"}); resp.Err != "" {
+		t.Fatal(resp.Err)
+	}
+	if d.attachedTo(inUse.VolumeID) != "" {
+		t.Error("volume is still attached after the last restored reference was released")
+	}
+}
+
+func TestMigrateVersion0Metadata(t *testing.T) {
+	dir, cloud := testDir(t), newFakeCloud()
+	created := time.Now().UTC().Add(-time.Hour).Truncate(time.Second)
+	volumeID := addVolume(cloud, taggedVolumeName("data", nil), created)
+	writeMetadataFile(t, dir, "data", "")
+
+	d := startTestDriver(t, dir, cloud)
+	defer d.close()
+	state, err := d.readMetadata("data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &volumeState{
+		Version:      metadataVersion,
+		VolumeID:     volumeID,
+		DatacenterID: testDatacenterID,
+		Size:         1,
+		Filesystem:   defaultFilesystem,
+		CreatedAt:    created,
+		MountPoint:   filepath.Join(filepath.Dir(d.dir), "mnt", volumeID),
+		Mounts:       map[string]bool{},
+	}
+	if !reflect.DeepEqual(state, expected) {
+		t.Errorf("migrated metadata is %+v, expected %+v", state, expected)
+	}
+	if vol, ok := d.lookupVolume("data"); !ok || vol.VolumeID != volumeID {
+		t.Errorf("migrated volume is registered as %+v", vol)
+	}
+}
+
+//tmpFiles is returning the temporary files of atomic writes in the directory.
+func tmpFiles(t *testing.T, dir string) []string {
+	files, err := filepath.Glob(filepath.Join(dir, ".*"+metadataTmpSuffix+"*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := testDir(t)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "vol")
+	for _, content := range []string{"old", "new"} {
+		if err := writeFileAtomic(path, []byte(content), metadataFileMode); err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil || string(data) != content {
+			t.Errorf("file holds %q, expected %q: %v", data, content, err)
+		}
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != metadataFileMode {
+		t.Errorf("file has mode %v: %v", info.Mode(), err)
+	}
+
+	//A directory in the way fails the rename
+	blocked := filepath.Join(dir, "blocked")
+	if err := os.MkdirAll(filepath.Join(blocked, "entry"), metadataDirMode); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeFileAtomic(blocked, []byte("content"), metadataFileMode); err == nil {
+		t.Error("write replacing a directory succeeded")
+	}
+	if files := tmpFiles(t, dir); len(files) != 0 {
+		t.Errorf("failed write left the temporary files %v", files)
+	}
+}
+
+func TestStartRemovesInterruptedWrites(t *testing.T) {
+	dir, cloud := testDir(t), newFakeCloud()
+	seedVolume(t, dir, cloud, "data", "")
+	//A write interrupted before the rename leaves a truncated temporary file
+	writeMetadataFile(t, dir, ".data"+metadataTmpSuffix+"123", `{"version":1,"volu`)
+
+	d := startTestDriver(t, dir, cloud)
+	defer d.close()
+	if files := tmpFiles(t, d.metadataPath); len(files) != 0 {
+		t.Errorf("temporary files %v of interrupted writes were not removed", files)
+	}
+	if _, ok := d.lookupVolume(".data" + metadataTmpSuffix + "123"); ok {
+		t.Error("temporary file was registered as volume")
+	}
+	if _, ok := d.lookupVolume("data"); !ok {
+		t.Error("volume of the interrupted write was not restored from its metadata")
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+//Operation types polled with their own configuration.
+const (
+	operationCreate   = "create"
+	operationAttach   = "attach"
+	operationDetach   = "detach"
+	operationDelete   = "delete"
+	operationSnapshot = "snapshot"
+	operationResize   = "resize"
+	//operationDevice is waiting for the device of an attached volume to appear on the host.
+	operationDevice = "device"
+
+	//deviceReady is the state of a device set up with the size of its volume.
+	deviceReady = "ready"
+)
+
+//pollConfig represents how often and how long a request is polled.
+type pollConfig struct {
+	initialInterval time.Duration
+	maxInterval     time.Duration
+	timeout         time.Duration
+}
+
+//defaultPollConfigs returns the polling configuration of every operation type.
+func defaultPollConfigs() map[string]*pollConfig {
+	return map[string]*pollConfig{
+		operationCreate:   {initialInterval: time.Second, maxInterval: 10 * time.Second, timeout: 10 * time.Minute},
+		operationAttach:   {initialInterval: time.Second, maxInterval: 10 * time.Second, timeout: 5 * time.Minute},
+		operationDetach:   {initialInterval: time.Second, maxInterval: 10 * time.Second, timeout: 5 * time.Minute},
+		operationDelete:   {initialInterval: time.Second, maxInterval: 10 * time.Second, timeout: 5 * time.Minute},
+		operationSnapshot: {initialInterval: time.Second, maxInterval: 30 * time.Second, timeout: 30 * time.Minute},
+		operationResize:   {initialInterval: time.Second, maxInterval: 10 * time.Second, timeout: 10 * time.Minute},
+		operationDevice:   {initialInterval: 100 * time.Millisecond, maxInterval: time.Second, timeout: 2 * time.Minute},
+	}
+}
+
+//validate is checking the polling configuration of an operation type.
+func (c *pollConfig) validate(operation string) error {
+	if c.initialInterval <= 0 || c.maxInterval <= 0 || c.timeout <= 0 {
+		return fmt.Errorf("Polling intervals and timeout of %s requests have to be positive", operation)
+	}
+	if c.initialInterval > c.maxInterval {
+		return fmt.Errorf("Initial polling interval of %s requests %v is greater than the maximum interval %v", operation, c.initialInterval, c.maxInterval)
+	}
+	return nil
+}
+
+//waitTillProvisioned is waiting till a Profitbricks long executing request is
+//done, polling it with an exponential backoff till the operation times out or
+//the driver is shut down.
+func (d *Driver) waitTillProvisioned(operation string, path string) error {
+	//A retried call found the request already applied
+	if path == "" {
+		return nil
+	}
+
+	return d.poll(operation, "request "+path, "DONE", func() (string, error) {
+		request, err := d.client.GetRequestStatus(path)
+		if err != nil {
+			return "", fmt.Errorf("failed to get request status for %s: %v", path, err)
+		}
+		status := request.Metadata.Status
+		log.Debugf("Request status: %s", status)
+		log.Debugf("Request status path: %s", path)
+
+		if status == "FAILED" {
+			return status, fmt.Errorf("Request failed with following error: %s", request.Metadata.Message)
+		}
+		return status, nil
+	})
+}
+
+//waitTillAvailable is waiting till a volume is available. It is used for
+//volumes a retried call found created, whose create request is not known.
+func (d *Driver) waitTillAvailable(operation string, volumeID string) error {
+	return d.poll(operation, "volume "+volumeID, "AVAILABLE", func() (string, error) {
+		vol, err := d.client.GetVolume(d.datacenterID, volumeID)
+		if err != nil {
+			return "", fmt.Errorf("failed to get the state of volume %s: %v", volumeID, err)
+		}
+		if vol.Metadata == nil {
+			return "", nil
+		}
+		return vol.Metadata.State, nil
+	})
+}
+
+//poll is calling status with an exponential backoff till it reports the done
+//status or fails, the operation times out or the driver is shut down.
+func (d *Driver) poll(operation string, subject string, done string, status func() (string, error)) error {
+	config, ok := d.polling[operation]
+	if !ok {
+		config = defaultPollConfigs()[operation]
+	}
+
+	deadline := time.NewTimer(config.timeout)
+	defer deadline.Stop()
+
+	interval := config.initialInterval
+	for {
+		state, err := status()
+		if err != nil {
+			return err
+		}
+		if state == done {
+			return nil
+		}
+
+		wait := time.NewTimer(interval)
+		select {
+		case <-d.ctx.Done():
+			wait.Stop()
+			return fmt.Errorf("waiting for %s %s was cancelled on shutdown, last status: %s", operation, subject, state)
+		case <-deadline.C:
+			wait.Stop()
+			return fmt.Errorf("%s %s did not finish within %v, last status: %s", operation, subject, config.timeout, state)
+		case <-wait.C:
+		}
+
+		interval *= 2
+		if interval > config.maxInterval {
+			interval = config.maxInterval
+		}
+	}
+}
@@ -0,0 +1,367 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/go-plugins-helpers/sdk"
+	"github.com/profitbricks/profitbricks-sdk-go"
+)
+
+//Constances used by resizing volumes.
+const (
+	adminResizePath  = "/Admin.Resize"
+	pluginSocketDir  = "/run/docker/plugins"
+	pluginSocketName = driverName + ".sock"
+
+	//autoGrowOption opts a volume out of growing on mount when set to false.
+	autoGrowOption = "auto_grow"
+
+	//Triggers of growing a filesystem.
+	growTriggerResize = "resize"
+	growTriggerMount  = "mount"
+)
+
+//growEvent represents a filesystem grown by the plugin.
+type growEvent struct {
+	Time      time.Time `json:"time"`
+	Trigger   string    `json:"trigger"`
+	FromBytes uint64    `json:"fromBytes"`
+	ToBytes   uint64    `json:"toBytes"`
+}
+
+//resizeRequest represents a request to grow a volume.
+type resizeRequest struct {
+	Name string
+	//Size is the new size in GB.
+	Size int
+}
+
+//resizeResponse represents the result of growing a volume.
+type resizeResponse struct {
+	Name    string
+	OldSize int
+	Size    int
+	//FilesystemBytes is the capacity of the grown filesystem of a mounted volume.
+	FilesystemBytes uint64 `json:",omitempty"`
+	Err             string `json:",omitempty"`
+}
+
+//handleResize is serving the resize admin command on the plugin socket.
+func (d *Driver) handleResize(w http.ResponseWriter, r *http.Request) {
+	var req resizeRequest
+	if err := sdk.DecodeRequest(w, r, &req); err != nil {
+		return
+	}
+
+	res := d.Resize(req)
+	sdk.EncodeResponse(w, res, res.Err)
+}
+
+//Resize is growing a volume in the datacenter and its filesystem if the
+//volume is mounted on this host. Shrinking volumes is not supported.
+func (d *Driver) Resize(r resizeRequest) resizeResponse {
+	unlock := d.lockVolume(r.Name)
+	defer unlock()
+	log.Infof("Resizing volume %s to %d GB", r.Name, r.Size)
+
+	res := resizeResponse{Name: r.Name, Size: r.Size}
+	vol, err := d.lookupOrAdoptVolume(r.Name)
+	if err != nil {
+		res.Err = err.Error()
+		return res
+	}
+	if vol.Missing {
+		res.Err = fmt.Sprintf("Volume %q no longer exists in datacenter '%s'", r.Name, d.datacenterID)
+		return res
+	}
+	res.OldSize = vol.Size
+
+	if r.Size < vol.Size {
+		res.Err = fmt.Sprintf("Shrinking volume %q from %d GB to %d GB is not supported", r.Name, vol.Size, r.Size)
+		return res
+	}
+
+	if r.Size > vol.Size {
+		updateResp, err := d.client.UpdateVolume(d.datacenterID, vol.VolumeID, profitbricks.VolumeProperties{Size: r.Size})
+		if err != nil {
+			log.Errorf("failed to resize volume '%v': %v", r.Name, err)
+			res.Err = err.Error()
+			return res
+		}
+		err = d.waitTillProvisioned(operationResize, updateResp.Headers.Get("Location"))
+		if err != nil {
+			log.Error(err.Error())
+			res.Err = err.Error()
+			return res
+		}
+		d.invalidateRemoteVolumes()
+
+		//The filesystem is grown when the volume is mounted next, if not now
+		d.updateVolume(func() {
+			vol.Size = r.Size
+			vol.GrowPending = true
+		})
+		err = d.writeMetadata(r.Name, vol)
+		if err != nil {
+			log.Errorf("failed to write metadata file for volume '%v': %v", r.Name, err)
+			res.Err = err.Error()
+			return res
+		}
+		log.Infof("Volume %s resized from %d GB to %d GB in datacenter '%s'", r.Name, res.OldSize, r.Size, d.datacenterID)
+	}
+
+	if len(vol.Mounts) == 0 {
+		if vol.GrowPending {
+			log.Infof("Volume %s is not mounted, its filesystem is grown when it is mounted", r.Name)
+		}
+		return res
+	}
+
+	err = d.growFilesystem(r.Name, vol, growTriggerResize)
+	if err != nil {
+		log.Error(err.Error())
+		res.Err = err.Error()
+		return res
+	}
+	usage, err := d.utilities.FilesystemUsage(vol.MountPoint)
+	if err == nil {
+		res.FilesystemBytes = usage.CapacityBytes
+	}
+	return res
+}
+
+//growFilesystem is growing the filesystem of a mounted volume to the size of
+//the volume, once the device reports the new size.
+func (d *Driver) growFilesystem(name string, vol *volumeState, trigger string) error {
+	if !vol.GrowPending {
+		return nil
+	}
+
+	devicePath := vol.DeviceName
+	err := d.utilities.RescanDevice(devicePath)
+	if err != nil {
+		return fmt.Errorf("failed to rescan the device of volume %q: %v", name, err)
+	}
+	err = d.waitForDeviceSize(name, devicePath, uint64(vol.Size)*gigabyte)
+	if err != nil {
+		return err
+	}
+	return d.growMountedFilesystem(name, vol, trigger)
+}
+
+//waitForDeviceSize is waiting till the device of a volume reports the size,
+//as hypervisors report the new size of attached devices with a delay.
+func (d *Driver) waitForDeviceSize(name string, devicePath string, expected uint64) error {
+	subject := fmt.Sprintf("of device %s of volume %q to %d bytes", devicePath, name, expected)
+	return d.poll(operationResize, subject, deviceReady, func() (string, error) {
+		size, err := d.utilities.DeviceSize(devicePath)
+		if err != nil {
+			return "", fmt.Errorf("failed to get the device size of volume %q: %v", name, err)
+		}
+		if size >= expected {
+			return deviceReady, nil
+		}
+		return fmt.Sprintf("%d bytes", size), nil
+	})
+}
+
+//growMountedFilesystem is growing the filesystem of a mounted volume to the
+//size of its device and records it.
+func (d *Driver) growMountedFilesystem(name string, vol *volumeState, trigger string) error {
+	filesystem, err := d.utilities.Filesystem(vol.Filesystem)
+	if err != nil {
+		return err
+	}
+	devicePath := vol.DeviceName
+	fromBytes, err := filesystem.Size(devicePath, vol.MountPoint)
+	if err != nil {
+		return err
+	}
+	log.Infof("Growing %s filesystem on %s mounted at %s", filesystem.Name(), devicePath, vol.MountPoint)
+	err = filesystem.Grow(devicePath, vol.MountPoint)
+	if err != nil {
+		return err
+	}
+	toBytes, err := filesystem.Size(devicePath, vol.MountPoint)
+	if err != nil {
+		return err
+	}
+	log.Infof("Filesystem of volume %s grown from %d to %d bytes", name, fromBytes, toBytes)
+
+	d.updateVolume(func() {
+		vol.LastGrow = &growEvent{Time: time.Now().UTC(), Trigger: trigger, FromBytes: fromBytes, ToBytes: toBytes}
+		vol.GrowPending = false
+	})
+	err = d.writeMetadata(name, vol)
+	if err != nil {
+		log.Errorf("failed to write metadata file for volume '%v': %v", name, err)
+	}
+	return nil
+}
+
+//autoGrow is growing the filesystem of a volume just mounted when the volume
+//was resized, by the plugin or outside of it, or the filesystem is smaller
+//than the device. The filesystem is grown in the background once the device
+//has the new size. Failures are logged only, the volume stays usable.
+func (d *Driver) autoGrow(name string, vol *volumeState) {
+	trigger := growTriggerResize
+	if !vol.GrowPending {
+		if enabled, err := strconv.ParseBool(vol.Options[autoGrowOption]); err == nil && !enabled {
+			return
+		}
+		trigger = growTriggerMount
+
+		remote, err := d.client.GetVolume(d.datacenterID, vol.VolumeID)
+		if err != nil {
+			log.Errorf("failed to get volume '%v' to check its size: %v", name, err)
+			return
+		}
+		if remote.Properties.Size > vol.Size {
+			log.Infof("Volume %s was resized from %d GB to %d GB outside of the plugin", name, vol.Size, remote.Properties.Size)
+			d.updateVolume(func() {
+				vol.Size = remote.Properties.Size
+				vol.GrowPending = true
+			})
+		} else {
+			filesystem, err := d.utilities.Filesystem(vol.Filesystem)
+			if err != nil {
+				log.Errorf("failed to check the filesystem size of volume '%v': %v", name, err)
+				return
+			}
+			devicePath := vol.DeviceName
+			deviceSize, err := d.utilities.DeviceSize(devicePath)
+			if err != nil {
+				log.Errorf("failed to get the device size of volume '%v': %v", name, err)
+				return
+			}
+			filesystemSize, err := filesystem.Size(devicePath, vol.MountPoint)
+			if err != nil {
+				log.Errorf("failed to get the filesystem size of volume '%v': %v", name, err)
+				return
+			}
+			//Filesystems do not use the last partial block or allocation group
+			if filesystemSize >= deviceSize/100*99 {
+				return
+			}
+			log.Infof("Filesystem of volume %s has %d bytes, its device has %d bytes", name, filesystemSize, deviceSize)
+			d.updateVolume(func() { vol.GrowPending = true })
+		}
+	}
+
+	//A crash before the filesystem is grown leaves the grow pending for the next mount
+	d.saveMounts(name)
+	err := d.utilities.RescanDevice(vol.DeviceName)
+	if err != nil {
+		log.Errorf("failed to rescan the device of volume '%v': %v", name, err)
+		return
+	}
+
+	d.operations.Add(1)
+	go d.growInBackground(name, vol, trigger, vol.DeviceName, uint64(vol.Size)*gigabyte)
+}
+
+//growInBackground is growing the filesystem of a volume just mounted once its
+//device reports the expected size. The volume is only locked for growing the
+//filesystem, so waiting for the device holds up neither the mount nor other
+//operations. Failures are logged only, the grow stays pending.
+func (d *Driver) growInBackground(name string, vol *volumeState, trigger string, devicePath string, expected uint64) {
+	defer d.operations.Done()
+
+	err := d.waitForDeviceSize(name, devicePath, expected)
+	if err == nil {
+		unlock := d.locks.lock(name)
+		current, ok := d.lookupVolume(name)
+		if ok && current == vol && len(vol.Mounts) > 0 && vol.DeviceName == devicePath && vol.GrowPending {
+			err = d.growMountedFilesystem(name, vol, trigger)
+		} else {
+			log.Infof("Volume %s was unmounted before its filesystem was grown", name)
+		}
+		unlock()
+	}
+	if err != nil {
+		log.Errorf("failed to grow the filesystem of volume '%v': %v", name, err)
+	}
+}
+
+//pluginSocket is returning the socket of the running plugin in the socket
+//directory, unless it is set. A plugin started directly listens in the socket
+//directory, a managed plugin in a subdirectory named after its ID.
+func pluginSocket(socketDir string, socket string) (string, error) {
+	if socket != "" {
+		return socket, nil
+	}
+	socket = filepath.Join(socketDir, pluginSocketName)
+	if _, err := os.Stat(socket); err == nil {
+		return socket, nil
+	}
+
+	sockets, _ := filepath.Glob(filepath.Join(socketDir, "*", pluginSocketName))
+	switch len(sockets) {
+	case 0:
+		return "", fmt.Errorf("No plugin socket %s found in %s, select it with --socket", pluginSocketName, socketDir)
+	case 1:
+		return sockets[0], nil
+	}
+	return "", fmt.Errorf("Several plugin sockets found (%s), select one with --socket", strings.Join(sockets, ", "))
+}
+
+//runResize is running the resize admin command against the plugin socket and
+//returns the exit code.
+func runResize(args []string, socket string) int {
+	if len(args) != 2 {
+		fmt.Println("Usage: docker-volume-profitbricks resize <volume name> <size in GB>")
+		return 2
+	}
+	size, err := strconv.Atoi(args[1])
+	if err != nil || size <= 0 {
+		fmt.Printf("Invalid size %q, the size has to be a positive number of GB\n", args[1])
+		return 2
+	}
+
+	socket, err = pluginSocket(pluginSocketDir, socket)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+
+	body, _ := json.Marshal(resizeRequest{Name: args[0], Size: size})
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+		},
+	}}
+	resp, err := client.Post("http://plugin"+adminResizePath, sdk.DefaultContentTypeV1_1, bytes.NewReader(body))
+	if err != nil {
+		fmt.Printf("Failed to reach the plugin at %s: %v\n", socket, err)
+		return 1
+	}
+	defer resp.Body.Close()
+
+	var res resizeResponse
+	err = json.NewDecoder(resp.Body).Decode(&res)
+	if err != nil {
+		fmt.Printf("Failed to read the response of the plugin: %v\n", err)
+		return 1
+	}
+	if res.Err != "" {
+		fmt.Println(res.Err)
+		return 1
+	}
+
+	fmt.Printf("Volume %s resized from %d GB to %d GB\n", res.Name, res.OldSize, res.Size)
+	if res.FilesystemBytes > 0 {
+		fmt.Printf("Filesystem capacity is %d bytes\n", res.FilesystemBytes)
+	}
+	return 0
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+//cloudSize is returning the size of a volume in the fake cloud.
+func (d *testDriver) cloudSize(volumeID string) int {
+	d.cloud.Lock()
+	defer d.cloud.Unlock()
+	return d.cloud.volumes[volumeID].Volume.Properties.Size
+}
+
+func TestResizeMountedVolume(t *testing.T) {
+	d := newTestDriver(t)
+	defer d.close()
+
+	if resp := d.Create(volume.Request{Name: "vol"}); resp.Err != "" {
+		t.Fatal(resp.Err)
+	}
+	volumeID := d.volumeID(t, "vol")
+	d.formatted(volumeID)
+	if resp := d.Mount(volume.MountRequest{Name: "vol", ID: "container"}); resp.Err != "" {
+		t.Fatal(resp.Err)
+	}
+
+	d.host.setOutput("blockdev --getsize64 /dev/vdb", "2147483648\n", "", nil)
+	res := d.Resize(resizeRequest{Name: "vol", Size: 2})
+	if res.Err != "" || res.OldSize != 1 || res.Size != 2 {
+		t.Fatalf("resize returned %+v", res)
+	}
+	if size := d.cloudSize(volumeID); size != 2 {
+		t.Errorf("volume has %d GB in the datacenter, expected 2 GB", size)
+	}
+	if !d.executed("resize2fs /dev/vdb") {
+		t.Errorf("filesystem was not grown, executed %q", d.host.executed())
+	}
+	vol, _ := d.lookupVolume("vol")
+	if vol.Size != 2 || vol.GrowPending || vol.LastGrow == nil || vol.LastGrow.Trigger != growTriggerResize {
+		t.Errorf("resized volume has size %d, pending grow %t and last grow %+v", vol.Size, vol.GrowPending, vol.LastGrow)
+	}
+}
+
+func TestResizeUnmountedVolume(t *testing.T) {
+	d := newTestDriver(t)
+	defer d.close()
+
+	if resp := d.Create(volume.Request{Name: "vol"}); resp.Err != "" {
+		t.Fatal(resp.Err)
+	}
+	if res := d.Resize(resizeRequest{Name: "vol", Size: 2}); res.Err != "" {
+		t.Fatal(res.Err)
+	}
+	for _, executed := range d.host.executed() {
+		if strings.HasPrefix(executed, "resize2fs") {
+			t.Errorf("filesystem of an unmounted volume was grown with %s", executed)
+		}
+	}
+	if vol, _ := d.lookupVolume("vol"); !vol.GrowPending {
+		t.Error("filesystem of the unmounted volume is not grown on the next mount")
+	}
+}
+
+func TestResizeRejectsShrinking(t *testing.T) {
+	d := newTestDriver(t)
+	defer d.close()
+
+	d.host.setOutput("blockdev --getsize64 /dev/vdb", "2147483648\n", "", nil)
+	if resp := d.Create(volume.Request{Name: "vol", Options: map[string]string{"volume_size": "2"}}); resp.Err != "" {
+		t.Fatal(resp.Err)
+	}
+	res := d.Resize(resizeRequest{Name: "vol", Size: 1})
+	if !strings.Contains(res.Err, "Shrinking") {
+		t.Errorf("shrinking returned %+v", res)
+	}
+	if size := d.cloudSize(d.volumeID(t, "vol")); size != 2 {
+		t.Errorf("volume has %d GB in the datacenter after shrinking was refused", size)
+	}
+}
+
+func TestResizeUnknownVolume(t *testing.T) {
+	d := newTestDriver(t)
+	defer d.close()
+
+	if res := d.Resize(resizeRequest{Name: "unknown", Size: 2}); !strings.Contains(res.Err, "does not exist") {
+		t.Errorf("resizing an unknown volume returned %+v", res)
+	}
+}
+
+func TestPluginSocket(t *testing.T) {
+	dir := testDir(t)
+	defer os.RemoveAll(dir)
+
+	if _, err := pluginSocket(dir, ""); err == nil {
+		t.Error("socket was found in an empty directory")
+	}
+	managed := filepath.Join(dir, "0123abcd", pluginSocketName)
+	if err := os.MkdirAll(filepath.Dir(managed), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(managed, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if socket, err := pluginSocket(dir, ""); err != nil || socket != managed {
+		t.Errorf("found socket %q of the managed plugin: %v", socket, err)
+	}
+	if socket, err := pluginSocket(dir, "/tmp/other.sock"); err != nil || socket != "/tmp/other.sock" {
+		t.Errorf("returned socket %q instead of the selected one: %v", socket, err)
+	}
+
+	other := filepath.Join(dir, "4567ef01", pluginSocketName)
+	if err := os.MkdirAll(filepath.Dir(other), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(other, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pluginSocket(dir, ""); err == nil || !strings.Contains(err.Error(), "--socket") {
+		t.Errorf("several managed plugins returned %v", err)
+	}
+
+	direct := filepath.Join(dir, pluginSocketName)
+	if err := ioutil.WriteFile(direct, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if socket, err := pluginSocket(dir, ""); err != nil || socket != direct {
+		t.Errorf("found socket %q of the plugin started directly: %v", socket, err)
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	log "github.com/Sirupsen/logrus"
+)
+
+//rollbackAction represents a compensating action of an operation step.
+type rollbackAction struct {
+	description string
+	undo        func() error
+}
+
+//rollback collects compensating actions of the steps an operation has done,
+//so they can be undone in reverse order when a later step fails.
+type rollback struct {
+	name    string
+	actions []rollbackAction
+}
+
+//add is registering the compensating action of a step which has been done.
+func (r *rollback) add(description string, undo func() error) {
+	r.actions = append(r.actions, rollbackAction{description: description, undo: undo})
+}
+
+//run is undoing the registered steps in reverse order. Failing actions are
+//logged, so the remaining ones still get their chance, and the first failure
+//is returned.
+func (r *rollback) run() error {
+	var failure error
+	for i := len(r.actions) - 1; i >= 0; i-- {
+		action := r.actions[i]
+		log.Infof("Rolling back volume '%v': %s", r.name, action.description)
+		err := action.undo()
+		if err != nil {
+			log.Errorf("failed to roll back volume '%v', %s: %v", r.name, action.description, err)
+			if failure == nil {
+				failure = err
+			}
+		}
+	}
+	r.actions = nil
+	return failure
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/profitbricks/profitbricks-sdk-go"
+)
+
+//States of volumes reported to Docker.
+const (
+	volumeStateMounted   = "mounted"
+	volumeStateUnmounted = "unmounted"
+	volumeStateMissing   = "missing"
+	//volumeStateRemote is the state of volumes not registered on this host yet.
+	volumeStateRemote = "remote"
+)
+
+//volumeStatus is returning the status of a volume reported to Docker. The
+//local state is nil for volumes not registered on this host, the remote volume
+//is nil when it is not known in the datacenter.
+func (d *Driver) volumeStatus(state *volumeState, remote *profitbricks.Volume, attached map[string]string) map[string]interface{} {
+	status := map[string]interface{}{
+		"DatacenterID": d.datacenterID,
+	}
+
+	volumeID := ""
+	if remote != nil {
+		volumeID = remote.ID
+		status["SizeGB"] = remote.Properties.Size
+		status["Type"] = remote.Properties.Type
+		status["AvailabilityZone"] = remote.Properties.AvailabilityZone
+		for k, v := range leaseStatus(*remote) {
+			status[k] = v
+		}
+	}
+
+	if state == nil {
+		status["State"] = volumeStateRemote
+	} else {
+		volumeID = state.VolumeID
+		status["SizeGB"] = state.Size
+		status["Type"] = state.Type
+		status["Filesystem"] = state.Filesystem
+		status["MkfsOptions"] = state.MkfsOptions
+		status["MountOptions"] = state.MountOptions
+		status["Options"] = state.Options
+		status["CreatedAt"] = state.CreatedAt.Format(time.RFC3339)
+		status["References"] = len(state.Mounts)
+		if state.LastGrow != nil {
+			status["LastGrow"] = state.LastGrow
+		}
+		if state.GrowPending {
+			status["GrowPending"] = true
+		}
+
+		switch {
+		case state.Missing:
+			status["State"] = volumeStateMissing
+			status["Message"] = fmt.Sprintf("volume no longer exists in datacenter '%s'", d.datacenterID)
+		case len(state.Mounts) > 0:
+			status["State"] = volumeStateMounted
+			status["Usage"] = d.usageStatus(state.MountPoint)
+		default:
+			status["State"] = volumeStateUnmounted
+		}
+	}
+	status["VolumeID"] = volumeID
+
+	if server, ok := attached[volumeID]; ok {
+		status["AttachedServer"] = server
+		if server == d.serverID && state != nil && len(state.Mounts) > 0 {
+			status["DevicePath"] = state.DeviceName
+		}
+	}
+	return status
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+//Policies for taking over volumes attached to another server.
+const (
+	takeoverNever   = "never"
+	takeoverStopped = "stopped"
+	takeoverAlways  = "always"
+)
+
+//stoppedVMStates are vm states of servers which can not write to a volume anymore.
+var stoppedVMStates = map[string]bool{
+	"SHUTOFF":  true,
+	"SHUTDOWN": true,
+	"CRASHED":  true,
+}
+
+//attachedServers is returning the servers volumes of the datacenter are
+//attached to by volume ID.
+func (d *Driver) attachedServers() (map[string]string, error) {
+	servers, err := d.client.ListServers(d.datacenterID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list servers in dc '%v': %v", d.datacenterID, err)
+	}
+
+	attached := make(map[string]string)
+	for _, srv := range servers.Items {
+		if srv.Entities == nil || srv.Entities.Volumes == nil {
+			continue
+		}
+		for _, v := range srv.Entities.Volumes.Items {
+			attached[v.ID] = srv.ID
+		}
+	}
+	return attached, nil
+}
+
+//findAttachedServer is returning the server a volume is attached to, or an
+//empty string if it is not attached.
+func (d *Driver) findAttachedServer(volumeID string) (string, error) {
+	attached, err := d.attachedServers()
+	if err != nil {
+		return "", err
+	}
+	return attached[volumeID], nil
+}
+
+//takeOver is detaching a volume from another server according to the takeover
+//policy, so it can be attached to this server.
+func (d *Driver) takeOver(name string, volumeID string) error {
+	owner, err := d.findAttachedServer(volumeID)
+	if err != nil {
+		return err
+	}
+	if owner == "" || owner == d.serverID {
+		return nil
+	}
+
+	reason := ""
+	switch d.takeoverPolicy {
+	case takeoverAlways:
+		reason = "the takeover policy is always"
+	case takeoverStopped:
+		srv, err := d.client.GetServer(d.datacenterID, owner)
+		if isNotFound(err) {
+			reason = "the server no longer exists"
+		} else if err != nil {
+			return fmt.Errorf("failed to get server '%v' volume %q is attached to: %v", owner, name, err)
+		} else if stoppedVMStates[srv.Properties.VMState] {
+			reason = fmt.Sprintf("the server is %s", srv.Properties.VMState)
+		} else {
+			log.Warnf("Fencing: not taking over volume %s from server %s in vm state %s", name, owner, srv.Properties.VMState)
+			return fmt.Errorf("Volume %q is attached to server '%v' which is %s", name, owner, srv.Properties.VMState)
+		}
+	default:
+		log.Warnf("Fencing: not taking over volume %s from server %s, the takeover policy is %s", name, owner, d.takeoverPolicy)
+		return fmt.Errorf("Volume %q is attached to server '%v'", name, owner)
+	}
+
+	log.Warnf("Fencing: detaching volume %s from server %s to take it over, %s", name, owner, reason)
+	return d.detachVolumeFrom(owner, volumeID)
+}
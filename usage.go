@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+//Constances used by the filesystem usage reporting.
+const (
+	defaultUsageInterval        = 5 * time.Minute
+	defaultUsageWarningPercent  = 80
+	defaultUsageCriticalPercent = 90
+
+	//Levels of the filesystem usage.
+	usageLevelOK       = "ok"
+	usageLevelWarning  = "warning"
+	usageLevelCritical = "critical"
+)
+
+//usageConfig represents how often the filesystem usage of mounted volumes is
+//logged and when it is flagged.
+type usageConfig struct {
+	interval        time.Duration
+	warningPercent  float64
+	criticalPercent float64
+}
+
+//validate is checking the thresholds are percentages in ascending order.
+func (c usageConfig) validate() error {
+	if c.interval < 0 {
+		return fmt.Errorf("The usage interval must not be negative")
+	}
+	if c.warningPercent <= 0 || c.warningPercent > c.criticalPercent || c.criticalPercent > 100 {
+		return fmt.Errorf("The usage warning threshold has to be positive and not greater than the critical threshold, which must not exceed 100 percent")
+	}
+	return nil
+}
+
+//level is returning the level of the usage, the higher one of space and inodes.
+func (c usageConfig) level(usage *volumeUsage) string {
+	percent := usage.usedPercent()
+	if inodes := usage.inodesUsedPercent(); inodes > percent {
+		percent = inodes
+	}
+
+	switch {
+	case percent >= c.criticalPercent:
+		return usageLevelCritical
+	case percent >= c.warningPercent:
+		return usageLevelWarning
+	}
+	return usageLevelOK
+}
+
+//volumeUsage represents the usage of the filesystem of a mounted volume.
+type volumeUsage struct {
+	CapacityBytes uint64
+	UsedBytes     uint64
+	//FreeBytes are available to unprivileged users, so the reserved blocks are missing.
+	FreeBytes  uint64
+	Inodes     uint64
+	InodesUsed uint64
+	InodesFree uint64
+}
+
+//usedPercent is returning the used space like df does, relative to the space
+//available to unprivileged users.
+func (u *volumeUsage) usedPercent() float64 {
+	if u.UsedBytes+u.FreeBytes == 0 {
+		return 0
+	}
+	return float64(u.UsedBytes) * 100 / float64(u.UsedBytes+u.FreeBytes)
+}
+
+//inodesUsedPercent is returning the used inodes. Filesystems allocating inodes
+//dynamically report none.
+func (u *volumeUsage) inodesUsedPercent() float64 {
+	if u.Inodes == 0 {
+		return 0
+	}
+	return float64(u.InodesUsed) * 100 / float64(u.Inodes)
+}
+
+//roundPercent is rounding a percentage to one decimal place.
+func roundPercent(percent float64) float64 {
+	return math.Floor(percent*10+0.5) / 10
+}
+
+//usageStatus is returning the filesystem usage of a mounted volume for the
+//status reported to Docker.
+func (d *Driver) usageStatus(mountPoint string) map[string]interface{} {
+	usage, err := d.utilities.FilesystemUsage(mountPoint)
+	if err != nil {
+		log.Errorf("failed to get the filesystem usage of '%v': %v", mountPoint, err)
+		return nil
+	}
+
+	return map[string]interface{}{
+		"CapacityBytes":     usage.CapacityBytes,
+		"UsedBytes":         usage.UsedBytes,
+		"FreeBytes":         usage.FreeBytes,
+		"UsedPercent":       roundPercent(usage.usedPercent()),
+		"Inodes":            usage.Inodes,
+		"InodesUsed":        usage.InodesUsed,
+		"InodesFree":        usage.InodesFree,
+		"InodesUsedPercent": roundPercent(usage.inodesUsedPercent()),
+		"UsageLevel":        d.usage.level(usage),
+	}
+}
+
+//reportUsage is logging the filesystem usage of mounted volumes every usage
+//interval till the driver is shut down.
+func (d *Driver) reportUsage() {
+	ticker := time.NewTicker(d.usage.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-d.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		d.RLock()
+		names := make([]string, 0, len(d.volumes))
+		for name := range d.volumes {
+			names = append(names, name)
+		}
+		d.RUnlock()
+
+		for _, name := range names {
+			if d.ctx.Err() != nil {
+				return
+			}
+			d.logUsage(name)
+		}
+	}
+}
+
+//logUsage is logging the filesystem usage of a volume if it is mounted. The
+//log level follows the usage level, so alerts can be based on the log.
+func (d *Driver) logUsage(name string) {
+	unlock := d.lockVolume(name)
+	vol, ok := d.lookupVolume(name)
+	if !ok || vol.Missing || len(vol.Mounts) == 0 {
+		unlock()
+		return
+	}
+	mountPoint := vol.MountPoint
+	unlock()
+
+	//statfs might hang on a broken device, so the volume is not locked
+	usage, err := d.utilities.FilesystemUsage(mountPoint)
+	if err != nil {
+		log.Errorf("failed to get the filesystem usage of volume '%v': %v", name, err)
+		return
+	}
+
+	message := fmt.Sprintf("Filesystem usage of volume %s: %.1f%% used, %d of %d bytes free, %.1f%% inodes used",
+		name, usage.usedPercent(), usage.FreeBytes, usage.CapacityBytes, usage.inodesUsedPercent())
+	switch d.usage.level(usage) {
+	case usageLevelCritical:
+		log.Errorf("%s, above the critical threshold of %.0f%%", message, d.usage.criticalPercent)
+	case usageLevelWarning:
+		log.Warnf("%s, above the warning threshold of %.0f%%", message, d.usage.warningPercent)
+	default:
+		log.Info(message)
+	}
+}
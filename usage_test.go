@@ -0,0 +1,108 @@
+package main
+
+import (
+	"reflect"
+	"syscall"
+	"testing"
+
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+//testUsageConfig are the default thresholds without logging the usage.
+var testUsageConfig = usageConfig{warningPercent: defaultUsageWarningPercent, criticalPercent: defaultUsageCriticalPercent}
+
+func TestUsageLevel(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		usage    volumeUsage
+		expected string
+	}{
+		{"empty filesystem", volumeUsage{}, usageLevelOK},
+		{"below warning", volumeUsage{UsedBytes: 799, FreeBytes: 201}, usageLevelOK},
+		{"at warning", volumeUsage{UsedBytes: 80, FreeBytes: 20}, usageLevelWarning},
+		{"below critical", volumeUsage{UsedBytes: 899, FreeBytes: 101}, usageLevelWarning},
+		{"at critical", volumeUsage{UsedBytes: 90, FreeBytes: 10}, usageLevelCritical},
+		{"full", volumeUsage{UsedBytes: 100}, usageLevelCritical},
+		{"reserved blocks not available", volumeUsage{CapacityBytes: 100, UsedBytes: 85, FreeBytes: 10}, usageLevelWarning},
+		{"inodes at warning", volumeUsage{UsedBytes: 10, FreeBytes: 90, Inodes: 100, InodesUsed: 80}, usageLevelWarning},
+		{"inodes at critical", volumeUsage{UsedBytes: 10, FreeBytes: 90, Inodes: 100, InodesUsed: 95}, usageLevelCritical},
+		{"dynamic inodes", volumeUsage{UsedBytes: 10, FreeBytes: 90}, usageLevelOK},
+	} {
+		if level := testUsageConfig.level(&test.usage); level != test.expected {
+			t.Errorf("%s has level %s, expected %s", test.name, level, test.expected)
+		}
+	}
+}
+
+func TestUsageConfigValidate(t *testing.T) {
+	for _, test := range []struct {
+		config usageConfig
+		valid  bool
+	}{
+		{testUsageConfig, true},
+		{usageConfig{warningPercent: 90, criticalPercent: 90}, true},
+		{usageConfig{warningPercent: 50, criticalPercent: 100}, true},
+		{usageConfig{warningPercent: 0, criticalPercent: 90}, false},
+		{usageConfig{warningPercent: 95, criticalPercent: 90}, false},
+		{usageConfig{warningPercent: 80, criticalPercent: 101}, false},
+		{usageConfig{interval: -1, warningPercent: 80, criticalPercent: 90}, false},
+	} {
+		if err := test.config.validate(); (err == nil) != test.valid {
+			t.Errorf("config %+v returned %v", test.config, err)
+		}
+	}
+}
+
+func TestRoundPercent(t *testing.T) {
+	for percent, expected := range map[float64]float64{
+		0:           0,
+		100:         100,
+		12.34:       12.3,
+		12.25:       12.3,
+		79.96:       80,
+		200.0 / 3.0: 66.7,
+		100.0 / 3.0: 33.3,
+		0.04:        0,
+		99.94999:    99.9,
+		0.125:       0.1,
+	} {
+		if rounded := roundPercent(percent); rounded != expected {
+			t.Errorf("%v was rounded to %v, expected %v", percent, rounded, expected)
+		}
+	}
+}
+
+func TestUsageStatus(t *testing.T) {
+	cloud := newFakeCloud()
+	d := startConfiguredDriver(t, testDir(t), cloud, cloud, newTestHost(), func(args *CommandLineArgs) {
+		args.usage = &testUsageConfig
+	})
+	defer d.close()
+
+	if resp := d.Create(volume.Request{Name: "vol"}); resp.Err != "" {
+		t.Fatal(resp.Err)
+	}
+	d.formatted(d.volumeID(t, "vol"))
+	resp := d.Mount(volume.MountRequest{Name: "vol", ID: "container"})
+	if resp.Err != "" {
+		t.Fatal(resp.Err)
+	}
+	//850 of 1000 blocks are used, 50 are reserved for root
+	d.host.setStatfs(resp.Mountpoint, &syscall.Statfs_t{Bsize: 4096, Blocks: 1000, Bfree: 150, Bavail: 100, Files: 200, Ffree: 150})
+
+	expected := map[string]interface{}{
+		"CapacityBytes":     uint64(4096000),
+		"UsedBytes":         uint64(3481600),
+		"FreeBytes":         uint64(409600),
+		"UsedPercent":       89.5,
+		"Inodes":            uint64(200),
+		"InodesUsed":        uint64(50),
+		"InodesFree":        uint64(150),
+		"InodesUsedPercent": 25.0,
+		"UsageLevel":        usageLevelWarning,
+	}
+	usage := d.Get(volume.Request{Name: "vol"}).Volume.Status["Usage"]
+	if !reflect.DeepEqual(usage, expected) {
+		t.Errorf("mounted volume has the usage %v, expected %v", usage, expected)
+	}
+}
@@ -2,12 +2,11 @@ package main
 
 import (
 	"bytes"
-	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"os"
-	"os/exec"
+	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
 
 	log "github.com/Sirupsen/logrus"
@@ -15,16 +14,24 @@ import (
 )
 
 const (
-	productUUIDPath = "/sys/devices/virtual/dmi/id/product_uuid"
+	productUUIDPath   = "/sys/devices/virtual/dmi/id/product_uuid"
+	mountInfoPath     = "/proc/self/mountinfo"
+	sysClassBlockPath = "/sys/class/block"
 )
 
 //Utilities is main stucture.
 type Utilities struct {
+	host Host
 }
 
 //NewUtilities is a constructor.
 func NewUtilities() *Utilities {
-	return &Utilities{}
+	return NewUtilitiesWithHost(systemHost{})
+}
+
+//NewUtilitiesWithHost is a constructor using the provided host operations.
+func NewUtilitiesWithHost(host Host) *Utilities {
+	return &Utilities{host: host}
 }
 
 //GetConfValS is trying to load a string value from a config file.
@@ -48,19 +55,19 @@ func (m Utilities) GetConfValS(path string, value string) (string, error) {
 	return configValue, nil
 }
 
-//MountVolume is trying to mount a volume.
-func (m Utilities) MountVolume(volumeName string, mountPoint string) error {
-	log.Infof("Mounting volume %s at %s", volumeName, mountPoint)
+//MountVolume is trying to mount a volume with the validated mount options.
+func (m Utilities) MountVolume(volumeName string, mountPoint string, options []string) error {
+	log.Infof("Mounting volume %s at %s with options %v", volumeName, mountPoint, options)
 
-	var stdOut, stdErr bytes.Buffer
-	cmd := exec.Command("mount", volumeName, mountPoint)
-	cmd.Stdout = &stdOut
-	cmd.Stderr = &stdErr
-	err := cmd.Run()
-	log.Infof("Mount stdout: %s", stdOut.String())
+	args := []string{}
+	if len(options) > 0 {
+		args = append(args, "-o", strings.Join(options, ","))
+	}
+	stdOut, stdErr, err := m.host.Run("mount", append(args, volumeName, mountPoint)...)
+	log.Infof("Mount stdout: %s", stdOut)
 
 	if err != nil {
-		return fmt.Errorf("Error occurred while mounting %s: %s", volumeName, stdErr.String())
+		return fmt.Errorf("Error occurred while mounting %s: %s", volumeName, stdErr)
 	}
 	return err
 }
@@ -68,95 +75,154 @@ func (m Utilities) MountVolume(volumeName string, mountPoint string) error {
 //UnmountVolume is trying to unmount a volume.
 func (m Utilities) UnmountVolume(mountPoint string) error {
 	log.Infof("Unmounting volume %s ", mountPoint)
-	var stdOut, stdErr bytes.Buffer
-	cmd := exec.Command("umount", mountPoint)
-	cmd.Stdout = &stdOut
-	cmd.Stderr = &stdErr
-	err := cmd.Run()
-	log.Infof("Umount stdout: %s", stdOut.String())
+	stdOut, stdErr, err := m.host.Run("umount", mountPoint)
+	log.Infof("Umount stdout: %s", stdOut)
 
 	if err != nil {
-		return fmt.Errorf("Error occurred while unmounting %s: %s", mountPoint, stdErr.String())
+		return fmt.Errorf("Error occurred while unmounting %s: %s", mountPoint, stdErr)
 	}
 	return err
 }
 
-//FormatVolume is formating a volume.
-func (m Utilities) FormatVolume(volumeName string, volumeID string) error {
-	log.Infof("Formating volume %s with uuid %s", volumeName, volumeID)
-	var stdOut, stdErr bytes.Buffer
-	cmd := exec.Command("mkfs.ext4", volumeName, "-U", volumeID)
-	cmd.Stdout = &stdOut
-	cmd.Stderr = &stdErr
-	return cmd.Run()
+//GetMountPoints is returning mount points of the host with their mount source.
+func (m Utilities) GetMountPoints() (map[string]string, error) {
+	data, err := m.host.ReadFile(mountInfoPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseMountInfo(string(data)), nil
 }
 
-//TuneVolume is setting a volume uuid to match profitbricks volume id.
-func (m Utilities) TuneVolume(volumeName string, volumeID string) error {
-	log.Infof("Tuning volume %s with uuid %s", volumeName, volumeID)
-	var stdOut, stdErr bytes.Buffer
-	cmd := exec.Command("tune2fs", volumeName, "-U", volumeID)
-	cmd.Stdout = &stdOut
-	cmd.Stderr = &stdErr
-	return cmd.Run()
+//parseMountInfo is parsing mount points and sources from a mountinfo file.
+func parseMountInfo(data string) map[string]string {
+	mountPoints := make(map[string]string)
+	for _, line := range strings.Split(data, "\n") {
+		// Format: 36 35 98:0 /root /mnt/point rw,noatime master:1 - ext4 /dev/vdb rw
+		fields := strings.Fields(line)
+		if len(fields) < 5 {
+			continue
+		}
+
+		source := ""
+		for i, field := range fields {
+			if field == "-" && i+2 < len(fields) {
+				source = unescapeMountInfo(fields[i+2])
+				break
+			}
+		}
+		mountPoints[unescapeMountInfo(fields[4])] = source
+	}
+	return mountPoints
+}
+
+//unescapeMountInfo is decoding octal escapes like \040 used in mountinfo.
+func unescapeMountInfo(value string) string {
+	var b bytes.Buffer
+	for i := 0; i < len(value); i++ {
+		if value[i] == '\\' && i+3 < len(value) {
+			if c, err := strconv.ParseUint(value[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(c))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(value[i])
+	}
+	return b.String()
 }
 
-//GetServerID is loading server id from a config file.
-func (m Utilities) GetServerID() (string, error) {
-	output, err := ioutil.ReadFile(productUUIDPath)
-	toReturn := string(output)
-	return strings.TrimSpace(toReturn), err
+//Filesystem is returning the operations of a filesystem type.
+func (m Utilities) Filesystem(name string) (Filesystem, error) {
+	return newFilesystem(name, m.host)
+}
+
+//DetectFilesystem is returning the type of the filesystem on a device, or an
+//empty string if the device has none.
+func (m Utilities) DetectFilesystem(devicePath string) (string, error) {
+	signature, err := m.ProbeDevice(devicePath)
+	if err != nil || signature["USAGE"] != "filesystem" {
+		return "", err
+	}
+	return signature["TYPE"], nil
+}
+
+//ProbeDevice is returning the signatures found on a device: a filesystem, a
+//partition table, a RAID or LVM member, and the devices stacked on top of it.
+func (m Utilities) ProbeDevice(devicePath string) (deviceSignature, error) {
+	signature := deviceSignature{}
+	stdOut, stdErr, err := m.host.Run("blkid", "-p", "-o", "export", devicePath)
+	//Exit code 2 means no signature was found
+	if err != nil && exitCode(err) != 2 {
+		return nil, fmt.Errorf("Error occurred while probing %s: %s", devicePath, stdErr)
+	}
+	for _, line := range strings.Split(stdOut, "\n") {
+		fields := strings.SplitN(strings.TrimSpace(line), "=", 2)
+		if len(fields) == 2 && fields[0] != "DEVNAME" {
+			signature[fields[0]] = fields[1]
+		}
+	}
+
+	//Device mapper targets like LVM or dm-crypt hold the device
+	device, err := m.host.EvalSymlinks(devicePath)
+	if err != nil {
+		return nil, err
+	}
+	holders, err := m.host.ReadDir(filepath.Join(sysClassBlockPath, filepath.Base(device), "holders"))
+	if err == nil && len(holders) > 0 {
+		signature["HOLDERS"] = strings.Join(holders, ",")
+	}
+	return signature, nil
 }
 
-//WriteLsblk is writing to a metadata file.
-func (m Utilities) WriteLsblk(metadataPath string, result Result) error {
-	jsn, err := json.MarshalIndent(result, "\t", "\t")
+//RescanDevice is making the kernel read the size of a resized device. Devices
+//without a rescan trigger, like virtio disks, pick up the new size by themselves.
+func (m Utilities) RescanDevice(devicePath string) error {
+	device, err := m.host.EvalSymlinks(devicePath)
 	if err != nil {
 		return err
 	}
-	ioutil.WriteFile(metadataPath, jsn, 0644)
 
-	return err
+	rescanPath := filepath.Join(sysClassBlockPath, filepath.Base(device), "device", "rescan")
+	if !m.host.Exists(rescanPath) {
+		return nil
+	}
+	log.Infof("Rescanning device %s", device)
+	return m.host.WriteFile(rescanPath, []byte("1"))
 }
 
-//getNewLsbkl is getting a lsbkl value.
-func (m Utilities) getNewLsblk() (Result, error) {
-	cmd := exec.Command("lsblk", "-P", "-o", "NAME,MOUNTPOINT,TYPE,UUID")
+//DeviceSize is returning the size of a block device in bytes.
+func (m Utilities) DeviceSize(devicePath string) (uint64, error) {
+	stdOut, stdErr, err := m.host.Run("blockdev", "--getsize64", devicePath)
+	if err != nil {
+		return 0, fmt.Errorf("Error occurred while getting the size of %s: %s", devicePath, stdErr)
+	}
+	return strconv.ParseUint(strings.TrimSpace(stdOut), 10, 64)
+}
 
-	data, err := cmd.CombinedOutput()
+//FilesystemUsage is returning the usage of the filesystem mounted at the mount point.
+func (m Utilities) FilesystemUsage(mountPoint string) (*volumeUsage, error) {
+	stat, err := m.host.Statfs(mountPoint)
 	if err != nil {
-		return Result{}, fmt.Errorf("Error: %s", err.Error())
-	}
-	result := []*Device{}
-	devices := strings.Split(string(data), "\n")
-	for _, device := range devices {
-		parsed := parseDevice(device)
-		if parsed != nil {
-			result = append(result, parsed)
-		}
+		return nil, err
 	}
 
-	return Result{Devices: result}, err
+	blockSize := uint64(stat.Bsize)
+	return &volumeUsage{
+		CapacityBytes: stat.Blocks * blockSize,
+		UsedBytes:     (stat.Blocks - stat.Bfree) * blockSize,
+		FreeBytes:     stat.Bavail * blockSize,
+		Inodes:        stat.Files,
+		InodesUsed:    stat.Files - stat.Ffree,
+		InodesFree:    stat.Ffree,
+	}, nil
 }
 
-//parseDevice is trying to parse a device name.
-func parseDevice(device string) *Device {
-	raw := strings.Split(device, " ")
-	if len(raw) == 4 {
-		name := strings.Split(raw[0], "=")[1]
-		mountpoint := strings.Split(raw[1], "=")[1]
-		_type := strings.Split(raw[2], "=")[1]
-		UUID := strings.Split(raw[3], "=")[1]
-
-		d := &Device{
-			Name:       strings.Trim(name, `"`),
-			Mountpoint: strings.Trim(mountpoint, `"`),
-			Type:       strings.Trim(_type, `"`),
-			UUID:       strings.Trim(UUID, `"`),
-		}
-		return d
-	}
-	return nil
+//GetServerID is loading server id from a config file.
+func (m Utilities) GetServerID() (string, error) {
+	output, err := m.host.ReadFile(productUUIDPath)
+	toReturn := string(output)
+	return strings.TrimSpace(toReturn), err
 }
 
 //RemoveMetaDataFile is removing a metadata from a file.
@@ -164,46 +230,8 @@ func (m Utilities) RemoveMetaDataFile(metadataFilePath string) error {
 	return os.Remove(metadataFilePath)
 }
 
-//GetDeviceName is getting a device name.
-func (m Utilities) GetDeviceName() (string, bool, error) {
-	deviceBaseName := "/dev/%s"
-	deviceName := ""
-	deviceCounter := 0
-	newList, err := m.getNewLsblk()
-
-	for _, device := range newList.Devices {
-		// Condition explanations:
-		// Every attached volume is type of a disk
-		// vda is reserved for OS based disk
-		// By default UUID is not assign to a attached volume, and we will make sure to do that for each of them
-		if device.Type == "disk" && device.Name != "vda" && len(device.UUID) == 0 {
-			deviceName = device.Name
-			deviceCounter++
-		}
-	}
-	if deviceCounter > 1 {
-		return "", false, fmt.Errorf("There is more than %d new devices", deviceCounter)
-	}
-
-	foundDevice := "" != deviceName
-	return fmt.Sprintf(deviceBaseName, deviceName), foundDevice, err
-}
-
 //IsUUID validates if a provided value is a uuid
 func (m Utilities) IsUUID(value string) bool {
 	var validUUID = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
 	return validUUID.MatchString(value)
 }
-
-//Result represents an array of devices.
-type Result struct {
-	Devices []*Device `json:"blockdevices"`
-}
-
-//Device represents a device meta data.
-type Device struct {
-	Name       string
-	Type       string
-	Mountpoint string
-	UUID       string
-}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"errors"
+	"os/exec"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+//exitError is returning the error of a command exiting with the code.
+func exitError(t *testing.T, code int) error {
+	err := exec.Command("sh", "-c", "exit "+strconv.Itoa(code)).Run()
+	if exitCode(err) != code {
+		t.Fatalf("failed to produce exit code %d: %v", code, err)
+	}
+	return err
+}
+
+func TestMountVolume(t *testing.T) {
+	host := newFakeHost()
+	utilities := NewUtilitiesWithHost(host)
+
+	err := utilities.MountVolume("/dev/vdb", "/mnt/vol", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = utilities.MountVolume("/dev/vdc", "/mnt/other", []string{"noatime", "commit=30"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"mount /dev/vdb /mnt/vol",
+		"mount -o noatime,commit=30 /dev/vdc /mnt/other",
+	}
+	if !reflect.DeepEqual(host.executed(), expected) {
+		t.Errorf("executed %q, expected %q", host.executed(), expected)
+	}
+}
+
+func TestMountVolumeFailure(t *testing.T) {
+	host := newFakeHost()
+	host.setOutput("mount", "", "wrong fs type", errors.New("exit status 32"))
+	utilities := NewUtilitiesWithHost(host)
+
+	err := utilities.MountVolume("/dev/vdb", "/mnt/vol", nil)
+	if err == nil || !strings.Contains(err.Error(), "wrong fs type") {
+		t.Errorf("expected the stderr of mount, got %v", err)
+	}
+}
+
+func TestUnmountVolumeFailure(t *testing.T) {
+	host := newFakeHost()
+	host.setOutput("umount /mnt/vol", "", "target is busy", errors.New("exit status 32"))
+	utilities := NewUtilitiesWithHost(host)
+
+	err := utilities.UnmountVolume("/mnt/vol")
+	if err == nil || !strings.Contains(err.Error(), "target is busy") {
+		t.Errorf("expected the stderr of umount, got %v", err)
+	}
+}
+
+func TestFormatVolume(t *testing.T) {
+	host := newFakeHost()
+	filesystem, err := NewUtilitiesWithHost(host).Filesystem(filesystemExt4)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = filesystem.Format("/dev/vdb", "uuid", "a-label-longer-than-16", []string{"-m", "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"mkfs.ext4 -U uuid -L a-label-longer-t -m 1 /dev/vdb"}
+	if !reflect.DeepEqual(host.executed(), expected) {
+		t.Errorf("executed %q, expected %q", host.executed(), expected)
+	}
+}
+
+func TestFormatVolumeFailure(t *testing.T) {
+	host := newFakeHost()
+	host.setOutput("mkfs.ext4", "", "", errors.New("executable file not found"))
+	filesystem, _ := NewUtilitiesWithHost(host).Filesystem(filesystemExt4)
+
+	err := filesystem.Format("/dev/vdb", "uuid", "label", nil)
+	if err == nil || !strings.Contains(err.Error(), "executable file not found") {
+		t.Errorf("expected the error of the missing tool, got %v", err)
+	}
+}
+
+func TestFormatFilesystems(t *testing.T) {
+	for _, test := range []struct {
+		filesystem string
+		options    []string
+		expected   string
+	}{
+		{filesystemXFS, []string{"-l", "size=32m"}, "mkfs.xfs -m uuid=uuid -L a-label-long -l size=32m /dev/vdb"},
+		{filesystemBtrfs, []string{"-m", "single"}, "mkfs.btrfs -U uuid -L a-label-longer-than-16 -m single /dev/vdb"},
+	} {
+		host := newFakeHost()
+		filesystem, err := NewUtilitiesWithHost(host).Filesystem(test.filesystem)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = filesystem.Format("/dev/vdb", "uuid", "a-label-longer-than-16", test.options)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(host.executed(), []string{test.expected}) {
+			t.Errorf("executed %q, expected %q", host.executed(), test.expected)
+		}
+	}
+}
+
+func TestGrowFilesystems(t *testing.T) {
+	for _, test := range []struct {
+		filesystem string
+		command    string
+		output     string
+		grow       string
+	}{
+		{filesystemExt4, "dumpe2fs", "Block count:              262144\nBlock size:               4096\n", "resize2fs /dev/vdb"},
+		{filesystemXFS, "xfs_info", "meta-data=/dev/vdb               isize=512    agcount=4, agsize=65536 blks\n" +
+			"data     =                       bsize=4096   blocks=262144, imaxpct=25\n", "xfs_growfs /mnt/vol"},
+		{filesystemBtrfs, "btrfs", "Label: 'vol'  uuid: uuid\n\tTotal devices 1 FS bytes used 114688\n" +
+			"\tdevid    1 size 1073741824 used 139919360 path /dev/vdb\n", "btrfs filesystem resize max /mnt/vol"},
+	} {
+		host := newFakeHost()
+		host.setOutput(test.command, test.output, "", nil)
+		filesystem, err := NewUtilitiesWithHost(host).Filesystem(test.filesystem)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := filesystem.Grow("/dev/vdb", "/mnt/vol"); err != nil {
+			t.Fatal(err)
+		}
+		if executed := host.executed(); len(executed) != 1 || executed[0] != test.grow {
+			t.Errorf("%s was grown with %q, expected %q", test.filesystem, executed, test.grow)
+		}
+		size, err := filesystem.Size("/dev/vdb", "/mnt/vol")
+		if err != nil || size != 1<<30 {
+			t.Errorf("%s has size %d: %v", test.filesystem, size, err)
+		}
+
+		host.setOutput(test.command, "", "", nil)
+		if _, err := filesystem.Size("/dev/vdb", "/mnt/vol"); err == nil {
+			t.Errorf("%s size was read from an empty output", test.filesystem)
+		}
+	}
+}
+
+func TestCheckCorrectedErrors(t *testing.T) {
+	host := newFakeHost()
+	host.setOutput("e2fsck", "", "", exitError(t, 1))
+	filesystem, _ := NewUtilitiesWithHost(host).Filesystem(filesystemExt4)
+	if err := filesystem.Check("/dev/vdb"); err != nil {
+		t.Errorf("corrected errors failed the check: %v", err)
+	}
+
+	host.setOutput("e2fsck", "", "bad superblock", exitError(t, 4))
+	if err := filesystem.Check("/dev/vdb"); err == nil {
+		t.Error("uncorrected errors passed the check")
+	}
+}
+
+func TestProbeDevice(t *testing.T) {
+	host := newFakeHost()
+	host.setFile("/dev/vdb", "")
+	host.setLink("/dev/disk/by-uuid/uuid", "/dev/vdb")
+	host.setOutput("blkid", "DEVNAME=/dev/vdb\nTYPE=LVM2_member\nUSAGE=raid\n", "", nil)
+	host.setFile("/sys/class/block/vdb/holders/dm-0", "")
+	utilities := NewUtilitiesWithHost(host)
+
+	signature, err := utilities.ProbeDevice("/dev/disk/by-uuid/uuid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := deviceSignature{"TYPE": "LVM2_member", "USAGE": "raid", "HOLDERS": "dm-0"}
+	if !reflect.DeepEqual(signature, expected) {
+		t.Errorf("probed %v, expected %v", signature, expected)
+	}
+}
+
+func TestProbeBlankDevice(t *testing.T) {
+	host := newFakeHost()
+	host.setFile("/dev/vdb", "")
+	host.setOutput("blkid", "", "", exitError(t, 2))
+	utilities := NewUtilitiesWithHost(host)
+
+	signature, err := utilities.ProbeDevice("/dev/vdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !signature.blank() {
+		t.Errorf("blank device has signature %v", signature)
+	}
+
+	host.setOutput("blkid", "", "permission denied", exitError(t, 4))
+	if _, err := utilities.ProbeDevice("/dev/vdb"); err == nil {
+		t.Error("failed probe returned no error")
+	}
+}
+
+func TestRescanDevice(t *testing.T) {
+	host := newFakeHost()
+	host.setFile("/dev/sdb", "")
+	host.setFile("/dev/vdb", "")
+	host.setFile("/sys/class/block/sdb/device/rescan", "")
+	utilities := NewUtilitiesWithHost(host)
+
+	if err := utilities.RescanDevice("/dev/sdb"); err != nil {
+		t.Fatal(err)
+	}
+	if content, _ := host.ReadFile("/sys/class/block/sdb/device/rescan"); string(content) != "1" {
+		t.Errorf("rescan of sdb was not triggered")
+	}
+
+	//Virtio disks have no rescan trigger
+	if err := utilities.RescanDevice("/dev/vdb"); err != nil {
+		t.Fatal(err)
+	}
+	if host.Exists("/sys/class/block/vdb/device/rescan") {
+		t.Errorf("rescan trigger of vdb was written")
+	}
+}
+
+func TestGetMountPoints(t *testing.T) {
+	host := newFakeHost()
+	host.setFile(mountInfoPath, "36 35 98:0 / /mnt/with\\040space rw,noatime - ext4 /dev/vdb rw\n")
+	mountPoints, err := NewUtilitiesWithHost(host).GetMountPoints()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mountPoints["/mnt/with space"] != "/dev/vdb" {
+		t.Errorf("parsed %v", mountPoints)
+	}
+}
+
+func TestParseMkfsOptions(t *testing.T) {
+	host := newFakeHost()
+	for _, test := range []struct {
+		filesystem string
+		options    string
+		valid      bool
+	}{
+		{filesystemExt4, "-i 8192 -m 1", true},
+		{filesystemExt4, "-E lazy_itable_init=0,discard", true},
+		{filesystemExt4, "-J device=/dev/vdc", false},
+		{filesystemExt4, "-J device=UUID=0a1b", false},
+		{filesystemExt4, "-E offset=4096", false},
+		{filesystemExt4, "-E root_owner=0:0,offset=4096", false},
+		{filesystemXFS, "-l size=32m -i maxpct=5", true},
+		{filesystemXFS, "-l logdev=/dev/vda", false},
+		{filesystemXFS, "-r rtdev=/dev/vdc", false},
+		{filesystemXFS, "-d file,name=image", false},
+		{filesystemXFS, "-s size=../../dev/vda", false},
+	} {
+		filesystem, err := newFilesystem(test.filesystem, host)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = parseMkfsOptions(filesystem, test.options)
+		if (err == nil) != test.valid {
+			t.Errorf("%s options %q returned %v", test.filesystem, test.options, err)
+		}
+	}
+}
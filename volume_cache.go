@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/go-plugins-helpers/volume"
+	"github.com/profitbricks/profitbricks-sdk-go"
+)
+
+//defaultVolumeCacheTTL is how long volumes listed in the datacenter are cached.
+const defaultVolumeCacheTTL = 30 * time.Second
+
+//volumeCache is caching the plugin-managed volumes of the datacenter by name.
+type volumeCache struct {
+	sync.Mutex
+	ttl       time.Duration
+	volumes   map[string]profitbricks.Volume
+	fetchedAt time.Time
+	//attached holds the servers volumes are attached to by volume ID.
+	attached   map[string]string
+	attachedAt time.Time
+}
+
+//newVolumeCache is a constructor of the datacenter volume cache.
+func newVolumeCache(ttl time.Duration) *volumeCache {
+	return &volumeCache{ttl: ttl}
+}
+
+//managedVolumeName is returning the Docker name of a volume tagged by the plugin.
+func managedVolumeName(v profitbricks.Volume) (string, bool) {
+	name, _, ok := parseVolumeName(v.Properties.Name)
+	return name, ok
+}
+
+//remoteVolumes is returning the plugin-managed volumes of the datacenter. The
+//cached volumes are returned when they can not be listed.
+func (d *Driver) remoteVolumes() map[string]profitbricks.Volume {
+	d.remote.Lock()
+	defer d.remote.Unlock()
+
+	if d.remote.volumes != nil && time.Since(d.remote.fetchedAt) < d.remote.ttl {
+		return d.remote.volumes
+	}
+
+	volumesresp, err := d.client.ListVolumes(d.datacenterID)
+	if err != nil {
+		log.Errorf("failed to list volumes in dc '%v': %v", d.datacenterID, err)
+		return d.remote.volumes
+	}
+
+	volumes := make(map[string]profitbricks.Volume)
+	for _, v := range volumesresp.Items {
+		if name, ok := managedVolumeName(v); ok {
+			volumes[name] = v
+		}
+	}
+	d.remote.volumes = volumes
+	d.remote.fetchedAt = time.Now()
+	return volumes
+}
+
+//cachedAttachedServers is returning the servers volumes of the datacenter are
+//attached to for status reports. The cached attachments are returned when the
+//servers can not be listed. Volumes are only attached, taken over or deleted
+//after checking the current attachments with attachedServers.
+func (d *Driver) cachedAttachedServers() (map[string]string, error) {
+	d.remote.Lock()
+	defer d.remote.Unlock()
+
+	if d.remote.attached != nil && time.Since(d.remote.attachedAt) < d.remote.ttl {
+		return d.remote.attached, nil
+	}
+
+	attached, err := d.attachedServers()
+	if err != nil {
+		return d.remote.attached, err
+	}
+	d.remote.attached = attached
+	d.remote.attachedAt = time.Now()
+	return attached, nil
+}
+
+//invalidateRemoteVolumes is making the next lookup list the datacenter volumes
+//and the servers they are attached to again.
+func (d *Driver) invalidateRemoteVolumes() {
+	d.remote.Lock()
+	defer d.remote.Unlock()
+	d.remote.fetchedAt = time.Time{}
+	d.remote.attachedAt = time.Time{}
+}
+
+//lookupOrAdoptVolume is returning the state of a volume, registering it first
+//when it was created by the plugin on another host. The caller has to hold
+//the volume lock.
+func (d *Driver) lookupOrAdoptVolume(name string) (*volumeState, error) {
+	if vol, ok := d.lookupVolume(name); ok {
+		return vol, nil
+	}
+
+	//The volume might have been created since the volumes were cached
+	d.invalidateRemoteVolumes()
+	v, ok := d.remoteVolumes()[name]
+	if !ok {
+		return nil, fmt.Errorf("Volume %q does not exist", name)
+	}
+
+	log.Infof("Volume %s exists in datacenter '%s', registering it", name, d.datacenterID)
+	entry, err := d.beginJournal(journalCreate, name)
+	if err != nil {
+		return nil, err
+	}
+	entry.VolumeID = v.ID
+
+	//The plugin-wide defaults were checked against the default filesystem on start
+	filesystem, err := d.utilities.Filesystem(d.filesystem)
+	if err != nil {
+		return nil, err
+	}
+	fsOptions, err := d.resolveFilesystemOptions(nil, filesystem, false)
+	if err != nil {
+		return nil, err
+	}
+
+	undo := &rollback{name: name}
+	err = d.registerVolume(volume.Request{Name: name}, v.ID, v.Properties.Size, v.Properties.Type, filesystem.Name(), fsOptions, "", undo, entry)
+	if err != nil {
+		if undo.run() == nil {
+			entry.finish()
+		}
+		return nil, err
+	}
+	entry.finish()
+
+	vol, _ := d.lookupVolume(name)
+	return vol, nil
+}